@@ -84,7 +84,11 @@ func main() {
 		for chunk := range iter {
 			fmt.Print(chunk)
 		}
-		messages = <-ch
+		res := <-ch
+		messages = res.Messages
 		fmt.Println()
+		if res.Err != nil {
+			fmt.Println("出错了：", res.Err)
+		}
 	}
 }
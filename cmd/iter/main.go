@@ -3,8 +3,9 @@ package main
 import (
 	"bufio"
 	"context"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"math"
 	"math/rand/v2"
 	"os"
@@ -12,6 +13,7 @@ import (
 	"time"
 
 	"github.com/eastlaugh/agent/pkg/agents"
+	"github.com/eastlaugh/agent/pkg/logging"
 	"github.com/eastlaugh/agent/pkg/openai"
 	"github.com/eastlaugh/agent/pkg/tools"
 )
@@ -42,6 +44,13 @@ func getUserInfo(userID int) string {
 }
 
 func main() {
+	plan := flag.Bool("plan", false, "以“计划-执行”模式运行：先列出计划，再逐步执行并修订")
+	flag.Parse()
+	var opts []agents.RunOption
+	if *plan {
+		opts = append(opts, agents.WithPlanning())
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	go Animation(ctx, 10, "正在启动 Agent 聊天系统")
 	time.Sleep(1 * time.Second)
@@ -52,20 +61,22 @@ func main() {
 		panic(err)
 	}
 	defer file.Close()
-	log.SetOutput(file)
+	logger := logging.New(file, logging.Options{Level: slog.LevelDebug})
 
 	client := openai.NewClient(os.Getenv("OPENAI_BASE_URL"), os.Getenv("OPENAI_API_KEY"), os.Getenv("OPENAI_MODEL"))
+	client.Logger = logger
+
+	reg := agents.NewRegistry().
+		MustRegister("random", rand.IntN, "返回 [0, n) 之间的随机整数").
+		MustRegister("user_info", getUserInfo, "用户ID为1到3").
+		MustRegister("getenv", os.Getenv, "读取环境变量", agents.Sensitive()).
+		MustRegister("now", func(layout string) string { return time.Now().Format(layout) }, "按 Go 的时间格式返回当前时间").
+		MustRegister("guess", NewPuzzle(), "猜数字游戏", agents.Alias("puzzle"))
+	reg.Namespace("web").
+		MustRegister("search", tools.SearchInternet, "在互联网上搜索信息，非必要不联网").
+		MustRegister("get", tools.HttpGet, "发送 HTTP GET 请求，非必要不联网")
 
-	var agt *agents.Agent
-	agt = agents.New(client, nil,
-		rand.IntN, "",
-		getUserInfo, "用户ID为1到3",
-		os.Getenv, "",
-		time.Now().Format, "",
-		NewPuzzle(), "猜数字游戏",
-		tools.SearchInternet, "在互联网上搜索信息，非必要不联网",
-		tools.HttpGet, "发送 HTTP GET 请求，非必要不联网",
-	)
+	agt := agents.NewWithRegistry(client, nil, reg).WithLogger(logger)
 
 	fmt.Println("欢迎使用 Agent 聊天系统！CTRL+C 退出。")
 
@@ -82,7 +93,7 @@ func main() {
 			continue
 		}
 
-		iter, ch := agt.Iter(messages, question)
+		iter, ch := agt.IterContext(context.Background(), messages, question, opts...)
 		iter2 := agents.ReactIter(iter)
 		for state, chunk := range iter2 {
 			switch state {
@@ -94,12 +105,20 @@ func main() {
 				fmt.Print(Red(chunk))
 			case agents.Answering:
 				fmt.Print(chunk)
+			case agents.Planning:
+				fmt.Print(Green(chunk))
+			case agents.Reviewing:
+				fmt.Print(Red(chunk))
 			default:
 				panic(state)
 			}
 		}
 		fmt.Println()
-		messages = <-ch
+		res := <-ch
+		messages = res.Messages
+		if res.Err != nil {
+			fmt.Println(Red("出错了：" + res.Err.Error()))
+		}
 	}
 
 	if err := scanner.Err(); err != nil {
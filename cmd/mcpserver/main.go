@@ -0,0 +1,71 @@
+// mcpserver 把内置工具通过 MCP 提供给其他客户端：默认使用 stdio，指定 -http 时在 /mcp 提供 streamable HTTP
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/eastlaugh/agent/pkg/logging"
+	"github.com/eastlaugh/agent/pkg/mcpserver"
+	"github.com/eastlaugh/agent/pkg/tools"
+)
+
+func main() {
+	httpAddr := flag.String("http", "", "serve streamable HTTP at /mcp on this address, e.g. :3000; empty serves stdio")
+	toolNames := flag.String("tools", "", "comma-separated tools, aliases or namespaces to expose; empty exposes all built-in tools")
+	logLevel := flag.String("log-level", "info", "minimum log level: debug, info, warn or error")
+	flag.Parse()
+
+	level, err := logging.ParseLevel(*logLevel)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "invalid -log-level")
+		os.Exit(2)
+	}
+	// stdout 属于 stdio 传输，日志只能写到 stderr
+	logger := logging.New(os.Stderr, logging.Options{Level: level})
+	slog.SetDefault(logger)
+
+	reg := tools.Builtin()
+	if *toolNames != "" {
+		reg, err = reg.Subset(strings.Split(*toolNames, ",")...)
+		if err != nil {
+			fatal(err)
+		}
+	}
+	srv := mcpserver.New(reg)
+	srv.Logger = logger
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if *httpAddr == "" {
+		logger.Info("serving MCP on stdio", "tools", reg.Names())
+		if err := srv.ServeStdio(ctx, os.Stdin, os.Stdout); err != nil && ctx.Err() == nil {
+			fatal(err)
+		}
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/mcp", srv)
+	hs := &http.Server{Addr: *httpAddr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		hs.Close()
+	}()
+	logger.Info("serving MCP over HTTP", "addr", *httpAddr, "path", "/mcp", "tools", reg.Names())
+	if err := hs.ListenAndServe(); err != http.ErrServerClosed {
+		fatal(err)
+	}
+}
+
+func fatal(err error) {
+	slog.Error(err.Error())
+	os.Exit(1)
+}
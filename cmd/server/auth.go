@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eastlaugh/agent/pkg/logging"
+)
+
+// authConfig 是 -auth 指定的 JSON 文件，例如：
+//
+//	{
+//		"allowedOrigins": ["https://chat.example.com"],
+//		"keys": [
+//			{"name": "alice", "key": "sk-agent-xxx", "requestsPerMinute": 30, "tokensPerDay": 200000},
+//			{"name": "kiosk", "key": "sk-agent-yyy", "deniedTools": ["web"]}
+//		]
+//	}
+type authConfig struct {
+	AllowedOrigins []string  `json:"allowedOrigins"`
+	Keys           []*apiKey `json:"keys"`
+}
+
+// apiKey 是一个客户端凭证及其配额，配额为 0 表示不限
+type apiKey struct {
+	Name              string `json:"name"`
+	Key               string `json:"key"`
+	RequestsPerMinute int    `json:"requestsPerMinute"`
+	TokensPerDay      int    `json:"tokensPerDay"`
+	// 该 key 发起的对话不能使用的工具，可以是工具名、别名或命名空间（如 web 禁止联网）
+	DeniedTools []string `json:"deniedTools"`
+
+	mu       sync.Mutex
+	minute   time.Time // 当前计数窗口的起点
+	requests int
+	day      string // 当前 token 计数对应的 UTC 日期
+	tokens   int
+}
+
+var errTokenQuota = errors.New("token quota exceeded")
+
+// allowRequest 记一次请求；超出每分钟请求数时返回 false 及建议的重试间隔
+func (k *apiKey) allowRequest() (bool, time.Duration) {
+	if k == nil || k.RequestsPerMinute <= 0 {
+		return true, 0
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	now := time.Now()
+	if now.Sub(k.minute) >= time.Minute {
+		k.minute, k.requests = now, 0
+	}
+	if k.requests >= k.RequestsPerMinute {
+		return false, k.minute.Add(time.Minute).Sub(now)
+	}
+	k.requests++
+	return true, 0
+}
+
+// checkTokens 在开始新一轮对话前检查当天的 token 配额
+func (k *apiKey) checkTokens() error {
+	if k == nil || k.TokensPerDay <= 0 {
+		return nil
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.rollDay()
+	if k.tokens >= k.TokensPerDay {
+		return fmt.Errorf("%w: used %d of %d tokens today (UTC)", errTokenQuota, k.tokens, k.TokensPerDay)
+	}
+	return nil
+}
+
+// addTokens 记入一轮对话实际消耗的 token；一轮进行中不会被打断，因此可能略超配额
+func (k *apiKey) addTokens(n int) {
+	if k == nil {
+		return
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.rollDay()
+	k.tokens += n
+}
+
+func (k *apiKey) rollDay() {
+	if today := time.Now().UTC().Format(time.DateOnly); k.day != today {
+		k.day, k.tokens = today, 0
+	}
+}
+
+// deniedTools 返回该 key 禁用的工具，nil 的 key 不禁用任何工具
+func (k *apiKey) deniedTools() []string {
+	if k == nil {
+		return nil
+	}
+	return k.DeniedTools
+}
+
+// owner 是会话归属的标识；未启用鉴权时所有会话都属于空 owner
+func (k *apiKey) owner() string {
+	if k == nil {
+		return ""
+	}
+	return k.Name
+}
+
+// withUser 把 key 的名称作为 user 属性附加到 ctx，日志与审计记录据此区分调用方
+func (k *apiKey) withUser(ctx context.Context) context.Context {
+	if k == nil {
+		return ctx
+	}
+	return logging.WithAttrs(ctx, slog.String("user", k.Name))
+}
+
+type apiKeyCtxKey struct{}
+
+// keyFromContext 返回请求所用的 key，未启用鉴权时为 nil（nil 的 *apiKey 不受任何限制）
+func keyFromContext(ctx context.Context) *apiKey {
+	k, _ := ctx.Value(apiKeyCtxKey{}).(*apiKey)
+	return k
+}
+
+type auth struct {
+	origins []string
+	keys    map[string]*apiKey // 为 nil 表示未启用鉴权
+}
+
+// loadAuth 读取鉴权配置；path 为空时不启用鉴权，并允许任意来源跨域
+func loadAuth(path string) (*auth, error) {
+	if path == "" {
+		return &auth{origins: []string{"*"}}, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg authConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	a := &auth{origins: cfg.AllowedOrigins, keys: map[string]*apiKey{}}
+	names := map[string]bool{}
+	for i, k := range cfg.Keys {
+		if k.Key == "" || k.Name == "" {
+			return nil, fmt.Errorf("%s: keys[%d]: name and key are required", path, i)
+		}
+		if a.keys[k.Key] != nil || names[k.Name] {
+			return nil, fmt.Errorf("%s: keys[%d]: duplicate name or key", path, i)
+		}
+		a.keys[k.Key] = k
+		names[k.Name] = true
+	}
+	return a, nil
+}
+
+// originAllowed 报告 allowedOrigins 是否允许 origin；不带 Origin 的请求不是来自浏览器，不受限制
+func (a *auth) originAllowed(origin string) bool {
+	return origin == "" || slices.Contains(a.origins, "*") || slices.Contains(a.origins, origin)
+}
+
+// cors 按 allowedOrigins 设置跨域响应头，返回值表示是否已作为预检请求处理完毕
+func (a *auth) cors(w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || !a.originAllowed(origin) {
+		return false
+	}
+	w.Header().Add("Vary", "Origin")
+	if slices.Contains(a.origins, "*") {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+	if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Last-Event-ID")
+		w.WriteHeader(http.StatusNoContent)
+		return true
+	}
+	return false
+}
+
+// bearer 从 Authorization 头读取 token；浏览器的 EventSource 与 WebSocket 无法设置请求头，
+// 因此也接受 access_token 查询参数
+func bearer(r *http.Request) string {
+	if h := r.Header.Get("Authorization"); h != "" {
+		token, ok := strings.CutPrefix(h, "Bearer ")
+		if !ok {
+			return ""
+		}
+		return strings.TrimSpace(token)
+	}
+	return r.URL.Query().Get("access_token")
+}
+
+// deny 以与接口一致的格式返回错误：/v1 下为 OpenAI 风格 JSON，其余为纯文本
+func deny(w http.ResponseWriter, r *http.Request, code int, msg string) {
+	if strings.HasPrefix(r.URL.Path, "/v1/") {
+		typ := "invalid_request_error"
+		switch code {
+		case http.StatusUnauthorized:
+			typ = "authentication_error"
+		case http.StatusTooManyRequests:
+			typ = "rate_limit_error"
+		}
+		completionError(w, code, typ, msg)
+		return
+	}
+	http.Error(w, msg, code)
+}
+
+// middleware 处理跨域、校验 key 并执行每分钟请求数限制
+func (a *auth) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.cors(w, r) || r.Method == http.MethodOptions {
+			return
+		}
+		// Prometheus 抓取 /metrics 时不带 key
+		if a.keys == nil || r.URL.Path == "/metrics" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := a.keys[bearer(r)]
+		if key == nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="agent"`)
+			deny(w, r, http.StatusUnauthorized, "missing or invalid API key")
+			return
+		}
+		if ok, retry := key.allowRequest(); !ok {
+			secs := int(retry.Seconds()) + 1
+			w.Header().Set("Retry-After", strconv.Itoa(secs))
+			deny(w, r, http.StatusTooManyRequests, fmt.Sprintf("rate limit exceeded: %d requests per minute, retry after %ds", key.RequestsPerMinute, secs))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyCtxKey{}, key)))
+	})
+}
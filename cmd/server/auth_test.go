@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCORSPreflight(t *testing.T) {
+	a := &auth{origins: []string{"https://chat.example.com"}, keys: map[string]*apiKey{}}
+	h := a.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("preflight reached the handler")
+	}))
+
+	req := httptest.NewRequest(http.MethodOptions, "/api/conversations/c1/tools", nil)
+	req.Header.Set("Origin", "https://chat.example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPut)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Origin") != "https://chat.example.com" {
+		t.Fatalf("status %d, headers %v", w.Code, w.Header())
+	}
+	if methods := w.Header().Get("Access-Control-Allow-Methods"); !strings.Contains(methods, http.MethodPut) {
+		t.Errorf("allowed methods = %q", methods)
+	}
+
+	// 不在 allowedOrigins 中的来源不返回跨域响应头
+	req.Header.Set("Origin", "https://evil.example.com")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("disallowed origin got Access-Control-Allow-Origin %q", got)
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/eastlaugh/agent/pkg/agents"
+	"github.com/eastlaugh/agent/pkg/openai"
+	"github.com/google/uuid"
+)
+
+// 未指定 -config 时对外暴露的模型名
+const agentModel = "react-agent"
+
+// completionRequest 是 OpenAI chat completions 请求，附带厂商扩展字段
+type completionRequest struct {
+	openai.CompletionRequest
+	// 为 true 时在响应中附带中间步骤（思考/动作/观察），见 x_agent_steps
+	IncludeSteps bool `json:"x_agent_include_steps,omitempty"`
+}
+
+type completionDelta struct {
+	Role    string `json:"role,omitempty"`
+	Content string `json:"content,omitempty"`
+}
+
+type completionChoice struct {
+	Index        int              `json:"index"`
+	Message      *openai.Message  `json:"message,omitempty"`
+	Delta        *completionDelta `json:"delta,omitempty"`
+	FinishReason *string          `json:"finish_reason"`
+}
+
+type completionResponse struct {
+	ID      string             `json:"id"`
+	Object  string             `json:"object"`
+	Created int64              `json:"created"`
+	Model   string             `json:"model"`
+	Choices []completionChoice `json:"choices"`
+	// 厂商扩展：非流式时为全部中间步骤，流式时为当前块对应的步骤
+	Steps []SSEData `json:"x_agent_steps,omitempty"`
+}
+
+// completionMessages 把客户端传来的完整对话拆成 Agent 的历史与当前问题。
+// 客户端的 system 消息作为 instructions 追加在 Agent 自身的 system prompt 之后，而不是替换它
+func completionMessages(msgs []openai.Message) (history []openai.Message, question string, instructions []string, err error) {
+	if len(msgs) == 0 || msgs[len(msgs)-1].Role != "user" {
+		return nil, "", nil, fmt.Errorf("the last message must have role user")
+	}
+	question = msgs[len(msgs)-1].Content
+
+	for _, m := range msgs[:len(msgs)-1] {
+		if m.Role == "system" || m.Role == "developer" {
+			instructions = append(instructions, m.Content)
+			continue
+		}
+		history = append(history, m)
+	}
+	return history, question, instructions, nil
+}
+
+func completionError(w http.ResponseWriter, code int, typ, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error": map[string]string{"message": msg, "type": typ},
+	})
+}
+
+// handleCompletions 实现 POST /v1/chat/completions：对客户端而言 Agent 就是一个模型，
+// 只返回最终答案，中间步骤仅在请求 x_agent_include_steps 时通过 x_agent_steps 返回
+func handleCompletions() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req completionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			completionError(w, http.StatusBadRequest, "invalid_request_error", err.Error())
+			return
+		}
+		st := current()
+		agt := st.agent(req.Model)
+		if agt == nil {
+			completionError(w, http.StatusNotFound, "invalid_request_error", fmt.Sprintf("the model %q does not exist", req.Model))
+			return
+		}
+		history, question, instructions, err := completionMessages(req.Messages)
+		if err == nil {
+			err = st.checkQuestion(question)
+		}
+		if err != nil {
+			completionError(w, http.StatusBadRequest, "invalid_request_error", err.Error())
+			return
+		}
+
+		resp := completionResponse{
+			ID:      "chatcmpl-" + uuid.New().String(),
+			Created: time.Now().Unix(),
+			Model:   req.Model,
+		}
+		if resp.Model == "" {
+			resp.Model = st.cfg.Models[0].Name
+		}
+
+		key := keyFromContext(r.Context())
+		if err := key.checkTokens(); err != nil {
+			completionError(w, http.StatusTooManyRequests, "rate_limit_error", err.Error())
+			return
+		}
+		var usage openai.UsageCounter
+		defer func() { key.addTokens(usage.Usage().TotalTokens) }()
+
+		ctx := openai.WithUsageCounter(key.withUser(r.Context()), &usage)
+		if d := st.runTimeout(); d > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, d)
+			defer cancel()
+		}
+		it, ch := agt.IterContext(ctx, history, question,
+			agents.WithInstructions(instructions...),
+			agents.WithoutTools(key.deniedTools()...),
+		)
+		if req.Stream {
+			streamCompletion(ctx, w, resp, agents.ReactIter(it), ch, req.IncludeSteps)
+		} else {
+			defer func() {
+				if r := recover(); r != nil {
+					slog.Error("completion panic", "panic", r)
+					completionError(w, http.StatusBadGateway, "api_error", fmt.Sprint(r))
+				}
+			}()
+			for state, chunk := range agents.ReactIter(it) {
+				if state != agents.Answering && req.IncludeSteps {
+					resp.Steps = appendStep(resp.Steps, state, chunk)
+				}
+			}
+			res := <-ch
+			if code, typ, err := completionFailure(ctx, res); err != nil {
+				slog.Error("completion failed", "stop_reason", res.Stop, "error", err)
+				completionError(w, code, typ, err.Error())
+				return
+			}
+			// 答案取自交付的历史：审查或校验拒绝后重新给出的答案会替换之前的答案，而不是接在后面
+			stop := "stop"
+			resp.Object = "chat.completion"
+			resp.Choices = []completionChoice{{
+				Message:      &openai.Message{Role: "assistant", Content: res.Answer()},
+				FinishReason: &stop,
+			}}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(resp)
+		}
+	}
+}
+
+// completionFailure 返回没有得到最终答案的运行对应的状态码、错误类型与错误；得到最终答案时 err 为 nil。
+// 超时、取消与转交结束的运行 Err 为 nil，同样视为失败
+func completionFailure(ctx context.Context, res agents.Transcript) (code int, typ string, err error) {
+	switch {
+	case res.Stop == agents.StopFinalAnswer:
+		return 0, "", nil
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return http.StatusGatewayTimeout, "timeout_error", errors.New("the run timed out before reaching a final answer")
+	case res.Err != nil:
+		return http.StatusBadGateway, "api_error", res.Err
+	default:
+		return http.StatusBadGateway, "api_error", fmt.Errorf("the run ended without a final answer (%s)", res.Stop)
+	}
+}
+
+// appendStep 把同一状态的连续文本合并为一个步骤
+func appendStep(steps []SSEData, state agents.ReAct, chunk string) []SSEData {
+	if n := len(steps); n > 0 && steps[n-1].State == state.String() {
+		steps[n-1].Content += chunk
+		return steps
+	}
+	return append(steps, SSEData{State: state.String(), Content: chunk})
+}
+
+func streamCompletion(ctx context.Context, w http.ResponseWriter, resp completionResponse, it iter.Seq2[agents.ReAct, string], ch <-chan agents.Transcript, includeSteps bool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		completionError(w, http.StatusInternalServerError, "api_error", "streaming not supported")
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	srvMetrics.activeStreams.With("completions").Inc()
+	defer srvMetrics.activeStreams.With("completions").Dec()
+
+	resp.Object = "chat.completion.chunk"
+	send := func(delta completionDelta, finish *string, steps []SSEData) {
+		resp.Choices = []completionChoice{{Delta: &delta, FinishReason: finish}}
+		resp.Steps = steps
+		jsonData, _ := json.Marshal(resp)
+		fmt.Fprintf(w, "data: %s\n\n", jsonData)
+		flusher.Flush()
+	}
+
+	// 响应头已发出，只能以流内错误告知客户端
+	sendError := func(typ, msg string) {
+		jsonData, _ := json.Marshal(map[string]any{"error": map[string]string{"message": msg, "type": typ}})
+		fmt.Fprintf(w, "data: %s\n\n", jsonData)
+		flusher.Flush()
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			slog.Error("completion panic", "panic", r)
+			sendError("api_error", fmt.Sprint(r))
+		}
+	}()
+
+	// 答案在运行结束、确定被采纳后才发出：WithCritic 或 RunTyped 拒绝答案后会重新作答，
+	// 已发出的 content 无法撤回。中间步骤仍实时推送
+	send(completionDelta{Role: "assistant"}, nil, nil)
+	for state, chunk := range it {
+		if state != agents.Answering && includeSteps {
+			send(completionDelta{}, nil, []SSEData{{State: state.String(), Content: chunk}})
+		}
+	}
+	res := <-ch
+	if _, typ, err := completionFailure(ctx, res); err != nil {
+		slog.Error("completion failed", "stop_reason", res.Stop, "error", err)
+		sendError(typ, err.Error())
+		return
+	}
+	stop := "stop"
+	send(completionDelta{Content: res.Answer()}, nil, nil)
+	send(completionDelta{}, &stop, nil)
+	fmt.Fprintf(w, "data: [DONE]\n\n")
+	flusher.Flush()
+}
+
+// handleModels 实现 GET /v1/models，许多聊天界面在连接前会先列出模型
+func handleModels(w http.ResponseWriter, r *http.Request) {
+	var data []map[string]any
+	for _, m := range current().cfg.Models {
+		data = append(data, map[string]any{"id": m.Name, "object": "model", "owned_by": "agent"})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"object": "list", "data": data})
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"iter"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eastlaugh/agent/pkg/agents"
+	"github.com/eastlaugh/agent/pkg/openai"
+)
+
+// hangingClient 在 ctx 结束前一直不回复
+type hangingClient struct{}
+
+func (hangingClient) Chat(ctx context.Context, messages []openai.Message, stop []string) (string, error) {
+	<-ctx.Done()
+	return "", ctx.Err()
+}
+
+func (hangingClient) ChatStream(ctx context.Context, messages []openai.Message, stop []string) (iter.Seq[string], error) {
+	return func(yield func(string) bool) { <-ctx.Done() }, nil
+}
+
+func TestCompletionTimeout(t *testing.T) {
+	active.Store(&setup{
+		cfg: &serverConfig{
+			Models: []modelConfig{{Name: "m"}},
+			Limits: limitsConfig{RunTimeout: duration(20 * time.Millisecond)},
+		},
+		agents: map[string]*agents.Agent{"m": agents.New(hangingClient{}, nil)},
+	})
+	handler := handleCompletions()
+
+	body := `{"model": "m", "messages": [{"role": "user", "content": "你好"}]}`
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body)))
+	var resp struct {
+		Error struct{ Type string } `json:"error"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusGatewayTimeout || resp.Error.Type != "timeout_error" {
+		t.Errorf("status %d, error type %q", w.Code, resp.Error.Type)
+	}
+
+	body = `{"model": "m", "stream": true, "messages": [{"role": "user", "content": "你好"}]}`
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body)))
+	if out := w.Body.String(); !strings.Contains(out, `"type":"timeout_error"`) || strings.Contains(out, `"finish_reason":"stop"`) || strings.Contains(out, "[DONE]") {
+		t.Errorf("stream = %s", out)
+	}
+}
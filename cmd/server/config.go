@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"text/template"
+	"time"
+
+	"github.com/eastlaugh/agent/pkg/agents"
+	"github.com/eastlaugh/agent/pkg/audit"
+	"github.com/eastlaugh/agent/pkg/openai"
+	"github.com/eastlaugh/agent/pkg/tools"
+	"github.com/eastlaugh/agent/pkg/trace"
+)
+
+// serverConfig 是 -config 指定的 JSON 文件，例如：
+//
+//	{
+//		"listen": ":8080",
+//		"providers": {
+//			"openai": {"baseURL": "https://api.openai.com/v1", "apiKeyEnv": "OPENAI_API_KEY"}
+//		},
+//		"models": [
+//			{"name": "react-agent", "provider": "openai", "model": "gpt-4o-mini"}
+//		],
+//		"tools": ["random.int", "time.now", "web.search", "web.get"],
+//		"systemPrompt": "{{.Prompt}}\n\n请使用中文回答。",
+//		"maxSteps": 10,
+//		"limits": {"maxQuestionBytes": 8192, "runTimeout": "5m"},
+//		"cache": {"responses": 1000, "ttl": "1h"},
+//		"runs": {"resumeGrace": "30s", "runRetention": "5m", "conversationTTL": "24h"}
+//	}
+//
+// 收到 SIGHUP 时重新加载，校验失败则保留原配置；listen 的修改需要重启才能生效
+type serverConfig struct {
+	Listen    string                     `json:"listen"`
+	Providers map[string]*providerConfig `json:"providers"`
+	// 对外暴露的模型，每个对应一个 Agent；第一个是 /api/chat 与 WebSocket 使用的默认模型
+	Models []modelConfig `json:"models"`
+	// 启用的内置工具，见 builtinTools
+	Tools []string `json:"tools"`
+	// text/template 模板，.Prompt 为生成的 ReAct 提示词，.Now 为当前时间；为空时直接使用 .Prompt
+	SystemPrompt string       `json:"systemPrompt"`
+	MaxSteps     int          `json:"maxSteps"`
+	Limits       limitsConfig `json:"limits"`
+	Cache        cacheConfig  `json:"cache"`
+	Runs         runsConfig   `json:"runs"`
+}
+
+// providerConfig 是一个 OpenAI 兼容的服务商；apiKeyEnv 优先于 apiKey，以免把密钥写进配置文件
+type providerConfig struct {
+	BaseURL   string `json:"baseURL"`
+	APIKey    string `json:"apiKey"`
+	APIKeyEnv string `json:"apiKeyEnv"`
+	// 为 false 时不在流式请求中携带 stream_options，用于不支持该字段的服务商
+	StreamUsage *bool `json:"streamUsage"`
+}
+
+type modelConfig struct {
+	Name     string `json:"name"`
+	Provider string `json:"provider"`
+	Model    string `json:"model"`
+}
+
+// limitsConfig 中为 0 的项表示不限
+type limitsConfig struct {
+	MaxQuestionBytes int      `json:"maxQuestionBytes"`
+	RunTimeout       duration `json:"runTimeout"`
+}
+
+// cacheConfig 配置模型回复的缓存，见 agents.CachedClient；重新加载配置时缓存会被清空
+type cacheConfig struct {
+	// 缓存的回复条数，所有模型共用，0 表示不缓存
+	Responses int `json:"responses"`
+	// 为 0 时使用 agents.CachedClient 的默认值
+	TTL duration `json:"ttl"`
+}
+
+// runsConfig 配置后台运行的一轮与会话在内存中保留多久，为 0 的项使用默认值
+type runsConfig struct {
+	// 以 resumable 发起的一轮在所有订阅者断开后继续运行、等待重连的时长，默认 30s；
+	// 其他的一轮在订阅者全部断开时立即中止
+	ResumeGrace duration `json:"resumeGrace"`
+	// 一轮结束后保留其事件供续传的时长，默认 5m
+	RunRetention duration `json:"runRetention"`
+	// 会话闲置超过该时长后删除，默认 24h
+	ConversationTTL duration `json:"conversationTTL"`
+}
+
+// runsConfig 各项的默认值
+const (
+	defaultResumeGrace     = 30 * time.Second
+	defaultRunRetention    = 5 * time.Minute
+	defaultConversationTTL = 24 * time.Hour
+)
+
+// duration 在 JSON 中写作 time.ParseDuration 接受的字符串，如 "90s"
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+// builtinTools 是配置文件中可以按名称启用的工具，见 tools.Builtin
+var builtinTools = tools.Builtin()
+
+// defaultConfig 是未指定 -config 时的配置，沿用 OPENAI_* 环境变量
+func defaultConfig() *serverConfig {
+	return &serverConfig{
+		Listen: ":8080",
+		Providers: map[string]*providerConfig{
+			"openai": {BaseURL: os.Getenv("OPENAI_BASE_URL"), APIKeyEnv: "OPENAI_API_KEY"},
+		},
+		Models:   []modelConfig{{Name: agentModel, Provider: "openai", Model: os.Getenv("OPENAI_MODEL")}},
+		Tools:    builtinTools.Names(),
+		MaxSteps: 10,
+	}
+}
+
+// loadConfig 读取并校验配置；path 为空时返回 defaultConfig
+func loadConfig(path string) (*serverConfig, error) {
+	if path == "" {
+		return defaultConfig(), nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &serverConfig{Listen: ":8080", MaxSteps: 10}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+func (c *serverConfig) validate() error {
+	var errs []error
+	if c.Listen == "" {
+		errs = append(errs, errors.New("listen is required"))
+	}
+	if len(c.Models) == 0 {
+		errs = append(errs, errors.New("at least one model is required"))
+	}
+	names := map[string]bool{}
+	for i, m := range c.Models {
+		switch {
+		case m.Name == "" || m.Model == "":
+			errs = append(errs, fmt.Errorf("models[%d]: name and model are required", i))
+		case names[m.Name]:
+			errs = append(errs, fmt.Errorf("models[%d]: duplicate name %q", i, m.Name))
+		case c.Providers[m.Provider] == nil:
+			errs = append(errs, fmt.Errorf("models[%d]: unknown provider %q", i, m.Provider))
+		}
+		names[m.Name] = true
+	}
+	for name, p := range c.Providers {
+		if p == nil {
+			errs = append(errs, fmt.Errorf("providers.%s: must be an object", name))
+		} else if p.APIKeyEnv != "" && os.Getenv(p.APIKeyEnv) == "" {
+			errs = append(errs, fmt.Errorf("providers.%s: environment variable %s is not set", name, p.APIKeyEnv))
+		}
+	}
+	for i, name := range c.Tools {
+		if _, ok := builtinTools.Lookup(name); !ok {
+			errs = append(errs, fmt.Errorf("tools[%d]: unknown tool %q, available: %s", i, name, strings.Join(builtinTools.Names(), ", ")))
+		} else if slices.Index(c.Tools, name) != i {
+			errs = append(errs, fmt.Errorf("tools[%d]: duplicate tool %q", i, name))
+		}
+	}
+	if _, err := c.prompter(); err != nil {
+		errs = append(errs, fmt.Errorf("systemPrompt: %w", err))
+	}
+	if c.MaxSteps <= 0 {
+		errs = append(errs, errors.New("maxSteps must be positive"))
+	}
+	if c.Limits.MaxQuestionBytes < 0 || c.Limits.RunTimeout < 0 {
+		errs = append(errs, errors.New("limits must not be negative"))
+	}
+	if c.Cache.Responses < 0 || c.Cache.TTL < 0 {
+		errs = append(errs, errors.New("cache must not be negative"))
+	}
+	if c.Runs.ResumeGrace < 0 || c.Runs.RunRetention < 0 || c.Runs.ConversationTTL < 0 {
+		errs = append(errs, errors.New("runs must not be negative"))
+	}
+	return errors.Join(errs...)
+}
+
+// prompter 把 systemPrompt 模板编译为 agents.New 的 prompter，并试执行一次以尽早暴露错误
+func (c *serverConfig) prompter() (func(string) string, error) {
+	if c.SystemPrompt == "" {
+		return nil, nil
+	}
+	tmpl, err := template.New("systemPrompt").Option("missingkey=error").Parse(c.SystemPrompt)
+	if err != nil {
+		return nil, err
+	}
+	type data struct {
+		Prompt string
+		Now    time.Time
+	}
+	if err := tmpl.Execute(new(strings.Builder), data{Now: time.Now()}); err != nil {
+		return nil, err
+	}
+	return func(prompt string) string {
+		var b strings.Builder
+		if err := tmpl.Execute(&b, data{Prompt: prompt, Now: time.Now()}); err != nil {
+			slog.Error("system prompt template", "error", err)
+			return prompt
+		}
+		return b.String()
+	}, nil
+}
+
+// setup 是由一份配置构建出的运行时对象，重新加载时整体替换
+type setup struct {
+	cfg    *serverConfig
+	agents map[string]*agents.Agent
+}
+
+// auditLog 是 -audit 指定的审计日志，为 nil 时不记录；重新加载配置时沿用同一个文件
+var auditLog *audit.Log
+
+// active 是当前生效的 setup；进行中的对话继续使用开始时的 Agent
+var active atomic.Pointer[setup]
+
+func current() *setup { return active.Load() }
+
+// agent 返回名为 model 的 Agent，model 为空时返回默认模型
+func (s *setup) agent(model string) *agents.Agent {
+	if model == "" {
+		model = s.cfg.Models[0].Name
+	}
+	return s.agents[model]
+}
+
+// checkQuestion 执行 limits.maxQuestionBytes
+func (s *setup) checkQuestion(question string) error {
+	if max := s.cfg.Limits.MaxQuestionBytes; max > 0 && len(question) > max {
+		return fmt.Errorf("question exceeds %d bytes", max)
+	}
+	return nil
+}
+
+// runTimeout 返回 limits.runTimeout，0 表示不限
+func (s *setup) runTimeout() time.Duration {
+	return time.Duration(s.cfg.Limits.RunTimeout)
+}
+
+// orDefault 返回 d，d 为 0 时返回 def
+func orDefault(d duration, def time.Duration) time.Duration {
+	if d == 0 {
+		return def
+	}
+	return time.Duration(d)
+}
+
+// resumeGrace 返回 runs.resumeGrace
+func (s *setup) resumeGrace() time.Duration {
+	return orDefault(s.cfg.Runs.ResumeGrace, defaultResumeGrace)
+}
+
+// runRetention 返回 runs.runRetention
+func (s *setup) runRetention() time.Duration {
+	return orDefault(s.cfg.Runs.RunRetention, defaultRunRetention)
+}
+
+// conversationTTL 返回 runs.conversationTTL
+func (s *setup) conversationTTL() time.Duration {
+	return orDefault(s.cfg.Runs.ConversationTTL, defaultConversationTTL)
+}
+
+// build 按配置创建客户端与 Agent
+func (c *serverConfig) build(logger *slog.Logger, tracer *trace.Tracer) (*setup, error) {
+	prompter, err := c.prompter()
+	if err != nil {
+		return nil, err
+	}
+	reg, err := builtinTools.Subset(c.Tools...)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &setup{cfg: c, agents: map[string]*agents.Agent{}}
+	var responses *agents.LRUCache
+	if c.Cache.Responses > 0 {
+		responses = agents.NewLRUCache(c.Cache.Responses)
+	}
+	for _, m := range c.Models {
+		p := c.Providers[m.Provider]
+		apiKey := p.APIKey
+		if p.APIKeyEnv != "" {
+			apiKey = os.Getenv(p.APIKeyEnv)
+		}
+		client := openai.NewClient(p.BaseURL, apiKey, m.Model)
+		if p.StreamUsage != nil {
+			client.StreamUsage = *p.StreamUsage
+		}
+		client.Metrics = srvMetrics
+		client.Logger = logger
+
+		var llm agents.Client = client
+		if responses != nil {
+			// 不同服务商的同名模型回复不同，键中带上服务商
+			cached := agents.NewCachedClient(client, m.Provider+"/"+m.Model, responses)
+			if c.Cache.TTL > 0 {
+				cached.WithTTL(time.Duration(c.Cache.TTL))
+			}
+			llm = cached
+		}
+		agt := agents.NewWithRegistry(llm, prompter, reg).
+			WithMaxSteps(c.MaxSteps).
+			WithMetrics(srvMetrics).
+			WithTracer(tracer).
+			WithLogger(logger)
+		if auditLog != nil {
+			agt.Use(auditLog.Middleware())
+		}
+		s.agents[m.Name] = agt
+	}
+	return s, nil
+}
+
+// reloadOnSIGHUP 在收到 SIGHUP 时重新读取 path 并替换 active
+func reloadOnSIGHUP(path string, logger *slog.Logger, tracer *trace.Tracer) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	for range ch {
+		cfg, err := loadConfig(path)
+		if err == nil {
+			var s *setup
+			if s, err = cfg.build(logger, tracer); err == nil {
+				if old := current(); cfg.Listen != old.cfg.Listen {
+					slog.Warn("listen address change requires a restart", "listen", old.cfg.Listen)
+				}
+				active.Store(s)
+				slog.Info("config reloaded", "path", path)
+				continue
+			}
+		}
+		slog.Error("config reload failed, keeping the previous config", "error", err)
+	}
+}
@@ -1,81 +1,270 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
-	"log"
-	"math/rand/v2"
+	"io"
+	"log/slog"
 	"net/http"
 	"os"
 	"sync"
 	"time"
 
-	"github.com/eastlaugh/agent/pkg/agents"
+	"github.com/eastlaugh/agent/pkg/audit"
+	"github.com/eastlaugh/agent/pkg/logging"
 	"github.com/eastlaugh/agent/pkg/openai"
-	"github.com/eastlaugh/agent/pkg/tools"
 	"github.com/google/uuid"
 )
 
 var (
 	convMu        sync.Mutex
-	conversations = map[string][]openai.Message{}
+	conversations = map[string]*conversation{}
 )
 
+// 会话状态
+const (
+	statusIdle      = "idle"
+	statusRunning   = "running"
+	statusDone      = "done"
+	statusCancelled = "cancelled"
+	// 超过 limits.runTimeout 而中止
+	statusTimeout = "timeout"
+)
+
+// interruptedNotes 是上一轮中止时下一轮附加的 instructions，使模型知道历史中上一轮的回答不完整
+var interruptedNotes = map[string]string{
+	statusCancelled: "上一轮回答已被取消，历史中上一轮的内容可能不完整。",
+	statusTimeout:   "上一轮回答超时中止，历史中上一轮的内容可能不完整。",
+}
+
+type conversation struct {
+	ID       string
+	Messages []openai.Message
+	Status   string
+	// 最近一轮中止时为 statusCancelled 或 statusTimeout，下一轮正常结束后清空；
+	// 中止不写入消息历史，历史只包含 user 与 assistant 消息
+	Interrupted string
+	// 所属 key 的名称，其他 key 看不到该会话
+	Owner string
+	// 会话可用的工具（工具名、别名或命名空间），为空表示全部；key 的 deniedTools 始终生效
+	Tools []string
+	// 最近一轮对话，保留到下一轮开始或超过 runs.runRetention，供断线重连
+	run *run
+	// 最近一次访问或一轮结束的时间，闲置超过 runs.conversationTTL 的会话会被删除
+	active time.Time
+}
+
 type ChatRequest struct {
 	ConversationId string `json:"conversationId"`
 	Question       string `json:"question"`
+	// 为 true 时客户端断开后本轮继续运行 runs.resumeGrace，可通过 GET /api/conversations/{id}/stream 续传
+	Resumable bool `json:"resumable,omitempty"`
 }
 
 type SSEData struct {
 	State   string `json:"state"`
 	Content string `json:"content"`
+	// 仅当 State 为 approval 时存在
+	Approval *approvalRequest `json:"approval,omitempty"`
+	// 子 Agent 的步骤带有嵌套深度与 Agent 名称，顶层 Agent 的输出省略这两个字段
+	Depth int    `json:"depth,omitempty"`
+	Agent string `json:"agent,omitempty"`
+}
+
+type toolsRequest struct {
+	Tools []string `json:"tools"`
+}
+
+// checkTools 校验会话指定的工具在当前配置中存在
+func checkTools(names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	_, err := current().agent("").Tools().Subset(names...)
+	return err
 }
 
-func corsOpts(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-	w.WriteHeader(http.StatusOK)
+// 除 ReAct 状态以外的事件状态
+const stateApproval = "approval"
+
+// getConversation 返回属于当前 key 的会话，不存在或属于其他 key 时返回 nil；
+// create 为 true 时会为当前 key 创建不存在的会话
+func getConversation(ctx context.Context, id string, create bool) *conversation {
+	owner := keyFromContext(ctx).owner()
+	convMu.Lock()
+	defer convMu.Unlock()
+	conv := conversations[id]
+	if conv == nil && create {
+		conv = &conversation{ID: id, Status: statusIdle, Owner: owner}
+		conversations[id] = conv
+	}
+	if conv == nil || conv.Owner != owner {
+		return nil
+	}
+	conv.active = time.Now()
+	return conv
+}
+
+// evictConversations 每分钟删除闲置超过 runs.conversationTTL 的会话，进行中的会话不删除
+func evictConversations() {
+	for range time.Tick(time.Minute) {
+		cutoff := time.Now().Add(-current().conversationTTL())
+		convMu.Lock()
+		for id, conv := range conversations {
+			if conv.Status != statusRunning && conv.active.Before(cutoff) {
+				delete(conversations, id)
+			}
+		}
+		convMu.Unlock()
+	}
 }
 
 func main() {
-	client := openai.NewClient(os.Getenv("OPENAI_BASE_URL"), os.Getenv("OPENAI_API_KEY"), os.Getenv("OPENAI_MODEL"))
-
-	agt := agents.New(client, nil,
-		rand.IntN, "",
-		time.Now().Format, "",
-		tools.SearchInternet, "在互联网上搜索信息",
-		tools.HttpGet, "发送 HTTP GET 请求",
-	)
-
-	http.HandleFunc("OPTIONS /api/conversations", corsOpts)
-	http.HandleFunc("OPTIONS /api/chat", corsOpts)
-	http.HandleFunc("GET /api/conversations/{id}", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
+	configFile := flag.String("config", "", "JSON config file (listen address, providers, models, tools, limits); reloaded on SIGHUP. Empty uses OPENAI_* environment variables")
+	authFile := flag.String("auth", "", "JSON file with API keys, quotas and allowed CORS origins; empty disables authentication")
+	traceTo := flag.String("trace", "", `span exporter: "stdout", an OTLP/HTTP endpoint such as http://localhost:4318/v1/traces, or a file for OTLP/JSON lines`)
+	logLevel := flag.String("log-level", "info", "minimum log level: debug, info, warn or error")
+	logFormat := flag.String("log-format", "text", "log format: text or json")
+	auditFile := flag.String("audit", "", "append a hash-chained JSONL record of every tool call to this file; check it with auditverify")
+	flag.Parse()
+
+	level, err := logging.ParseLevel(*logLevel)
+	if err != nil || (*logFormat != "text" && *logFormat != "json") {
+		fmt.Fprintln(os.Stderr, "invalid -log-level or -log-format")
+		os.Exit(2)
+	}
+	logger := logging.New(os.Stderr, logging.Options{Level: level, JSON: *logFormat == "json"})
+	slog.SetDefault(logger)
+
+	au, err := loadAuth(*authFile)
+	if err != nil {
+		fatal(err)
+	}
+	if au.keys == nil {
+		slog.Warn("authentication disabled, anyone who can reach the server can use it")
+	}
+
+	tracer, err := newTracer(*traceTo)
+	if err != nil {
+		fatal(err)
+	}
+
+	if *auditFile != "" {
+		auditLog, err = audit.Open(*auditFile)
+		if err != nil {
+			fatal(err)
+		}
+		auditLog.Logger = logger
+	}
+
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		fatal(err)
+	}
+	st, err := cfg.build(logger, tracer)
+	if err != nil {
+		fatal(err)
+	}
+	active.Store(st)
+	if *configFile != "" {
+		go reloadOnSIGHUP(*configFile, logger, tracer)
+	}
+	go evictConversations()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/conversations/{id}", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
-		id := r.PathValue("id")
+		conv := getConversation(r.Context(), r.PathValue("id"), false)
+		if conv == nil {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		convMu.Lock()
+		msgs, status, tools, interrupted := conv.Messages, conv.Status, conv.Tools, conv.Interrupted
+		convMu.Unlock()
+		resp := map[string]any{"messages": msgs, "status": status, "tools": tools}
+		if interrupted != "" {
+			resp["interrupted"] = interrupted
+		}
+		json.NewEncoder(w).Encode(resp)
+	})
+	mux.HandleFunc("PUT /api/conversations/{id}/tools", func(w http.ResponseWriter, r *http.Request) {
+		var req toolsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := checkTools(req.Tools); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		conv := getConversation(r.Context(), r.PathValue("id"), false)
+		if conv == nil {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		// 进行中的一轮不受影响，从下一轮开始生效
 		convMu.Lock()
-		msgs := conversations[id]
+		conv.Tools = req.Tools
 		convMu.Unlock()
-		if msgs == nil {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"tools": req.Tools})
+	})
+	mux.HandleFunc("POST /api/conversations/{id}/cancel", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		conv := getConversation(r.Context(), r.PathValue("id"), false)
+		if conv == nil {
 			http.Error(w, "not found", http.StatusNotFound)
 			return
 		}
-		json.NewEncoder(w).Encode(map[string]any{"messages": msgs})
+		convMu.Lock()
+		var rn *run
+		if conv.Status == statusRunning {
+			rn = conv.run
+		}
+		convMu.Unlock()
+		if rn == nil {
+			http.Error(w, "no running turn", http.StatusConflict)
+			return
+		}
+		rn.cancel()
+		json.NewEncoder(w).Encode(map[string]string{"status": statusCancelled})
 	})
-	http.HandleFunc("POST /api/conversations", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
+	mux.HandleFunc("GET /api/conversations/{id}/stream", func(w http.ResponseWriter, r *http.Request) {
+		var rn *run
+		if conv := getConversation(r.Context(), r.PathValue("id"), false); conv != nil {
+			convMu.Lock()
+			rn = conv.run
+			convMu.Unlock()
+		}
+		if rn == nil {
+			http.Error(w, "no run to resume", http.StatusNotFound)
+			return
+		}
+		rn.serveSSE(w, r, lastEventID(r))
+	})
+	mux.HandleFunc("POST /api/conversations", func(w http.ResponseWriter, r *http.Request) {
+		// 请求体可省略，也可以用 {"tools": [...]} 限定会话可用的工具
+		var req toolsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := checkTools(req.Tools); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		w.Header().Set("Content-Type", "application/json")
 		id := uuid.New().String()
+		conv := getConversation(r.Context(), id, true)
 		convMu.Lock()
-		conversations[id] = nil
+		conv.Tools = req.Tools
 		convMu.Unlock()
 		json.NewEncoder(w).Encode(map[string]string{"id": id})
 	})
-	http.HandleFunc("POST /api/chat", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-
+	mux.HandleFunc("POST /api/chat", func(w http.ResponseWriter, r *http.Request) {
 		var req ChatRequest
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
@@ -85,39 +274,43 @@ func main() {
 			http.Error(w, "conversationId required", http.StatusBadRequest)
 			return
 		}
-
-		convMu.Lock()
-		history := conversations[req.ConversationId]
-		convMu.Unlock()
-		// 只传历史，不传当前 user；Iter 内部会追加 user 并在 len(messages)==0 时注入 system prompt
-		iter, ch := agt.Iter(history, req.Question)
-
-		w.Header().Set("Content-Type", "text/event-stream")
-		w.Header().Set("Cache-Control", "no-cache")
-		w.Header().Set("Connection", "keep-alive")
-		flusher, ok := w.(http.Flusher)
-		if !ok {
+		st := current()
+		if err := st.checkQuestion(req.Question); err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		conv := getConversation(r.Context(), req.ConversationId, true)
+		if conv == nil {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		// 无法推送事件时不开始本轮：没有订阅者的非 resumable 运行不会因客户端断开而中止
+		if _, ok := w.(http.Flusher); !ok {
 			http.Error(w, "Streaming not supported", http.StatusInternalServerError)
 			return
 		}
 
-		iter2 := agents.ReactIter(iter)
-		for state, chunk := range iter2 {
-			jsonData, _ := json.Marshal(SSEData{State: state.String(), Content: chunk})
-			fmt.Fprintf(w, "data: %s\n\n", jsonData)
-			flusher.Flush()
+		// 本轮在后台运行；客户端断开时中止，除非请求带有 resumable：此时可通过
+		// GET /api/conversations/{id}/stream 续传，超过 runs.resumeGrace 无人重连、或调用 cancel 接口时才会中止
+		rn, err := startRun(st, conv, keyFromContext(r.Context()), req.Question, false, req.Resumable)
+		if err != nil {
+			http.Error(w, err.Error(), runErrorStatus(err))
+			return
 		}
-		msgs := <-ch
-		convMu.Lock()
-		conversations[req.ConversationId] = msgs
-		convMu.Unlock()
-
-		fmt.Fprintf(w, "data: [DONE]\n\n")
-		flusher.Flush()
+		rn.serveSSE(w, r, 0)
 	})
+	mux.HandleFunc("GET /api/ws", handleWebSocket(au))
+	mux.HandleFunc("POST /v1/chat/completions", handleCompletions())
+	mux.HandleFunc("GET /v1/models", handleModels)
+	mux.Handle("GET /metrics", srvMetrics)
 
-	addr := ":8080"
+	addr := cfg.Listen
+	slog.Info("server running", "listen", addr)
+	fatal(http.ListenAndServe(addr, au.middleware(mux)))
+}
 
-	log.Printf("Server running on http://localhost%s", addr)
-	log.Fatal(http.ListenAndServe(addr, nil))
+// fatal 记录错误后退出
+func fatal(err error) {
+	slog.Error(err.Error())
+	os.Exit(1)
 }
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/eastlaugh/agent/pkg/metrics"
+)
+
+// serverMetrics 同时实现 openai.Metrics 与 agents.Metrics，并记录服务端自身的指标
+type serverMetrics struct {
+	*metrics.Registry
+
+	llmLatency       *metrics.HistogramVec
+	llmErrors        *metrics.CounterVec
+	timeToFirstToken *metrics.HistogramVec
+	runSteps         *metrics.HistogramVec
+	toolCalls        *metrics.CounterVec
+	toolLatency      *metrics.HistogramVec
+	toolFailures     *metrics.CounterVec
+	formatViolations *metrics.CounterVec
+	activeStreams    *metrics.GaugeVec
+}
+
+var srvMetrics = newServerMetrics()
+
+func newServerMetrics() *serverMetrics {
+	r := metrics.NewRegistry()
+	return &serverMetrics{
+		Registry:         r,
+		llmLatency:       r.NewHistogramVec("agent_llm_request_duration_seconds", "LLM request latency; for streams, until the stream ends.", nil, "model", "stream"),
+		llmErrors:        r.NewCounterVec("agent_llm_request_errors_total", "Failed LLM requests.", "model", "stream"),
+		timeToFirstToken: r.NewHistogramVec("agent_llm_time_to_first_token_seconds", "Time from sending a streaming request to its first content chunk.", nil, "model"),
+		runSteps:         r.NewHistogramVec("agent_run_steps", "ReAct steps (tool calls) per run.", []float64{0, 1, 2, 3, 5, 8, 13, 21}),
+		toolCalls:        r.NewCounterVec("agent_tool_calls_total", "Tool invocations.", "tool"),
+		toolLatency:      r.NewHistogramVec("agent_tool_duration_seconds", "Tool execution latency.", nil, "tool"),
+		toolFailures:     r.NewCounterVec("agent_tool_failures_total", "Failed tool invocations, including panics, errors and middleware rejections.", "tool"),
+		formatViolations: r.NewCounterVec("agent_format_violations_total", "Model replies with neither an action nor a final answer."),
+		activeStreams:    r.NewGaugeVec("agent_active_streams", "Open streaming connections by transport (sse, websocket, completions).", "transport"),
+	}
+}
+
+func (m *serverMetrics) RequestDone(model string, stream bool, latency time.Duration, err error) {
+	s := strconv.FormatBool(stream)
+	m.llmLatency.With(model, s).Observe(latency.Seconds())
+	if err != nil {
+		m.llmErrors.With(model, s).Inc()
+	}
+}
+
+func (m *serverMetrics) FirstToken(model string, latency time.Duration) {
+	m.timeToFirstToken.With(model).Observe(latency.Seconds())
+}
+
+func (m *serverMetrics) RunDone(steps int) {
+	m.runSteps.With().Observe(float64(steps))
+}
+
+func (m *serverMetrics) ToolDone(tool string, latency time.Duration, failed bool) {
+	m.toolCalls.With(tool).Inc()
+	m.toolLatency.With(tool).Observe(latency.Seconds())
+	if failed {
+		m.toolFailures.With(tool).Inc()
+	}
+}
+
+func (m *serverMetrics) FormatViolation() {
+	m.formatViolations.With().Inc()
+}
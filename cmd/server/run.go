@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/eastlaugh/agent/pkg/agents"
+	"github.com/eastlaugh/agent/pkg/openai"
+	"github.com/google/uuid"
+)
+
+// event 是 run 产生的一条 SSE 事件，ID 在同一个 run 内从 1 开始单调递增
+type event struct {
+	ID   int
+	Data SSEData
+	Done bool // 结束事件，对应 SSE 中的 data: [DONE]
+}
+
+// run 表示会话中的一轮对话。它在独立的 goroutine 中执行，与发起请求的连接解耦，
+// 产生的事件缓存在内存中，直到该会话开始下一轮或结束后超过 runs.runRetention
+type run struct {
+	mu          sync.Mutex
+	events      []event
+	done        bool
+	notify      chan struct{} // 每次追加事件时关闭并替换，用于唤醒订阅者
+	subscribers int
+	idle        *time.Timer
+	// 所有订阅者断开后继续运行、等待重连的时长，为 0 时立即中止
+	grace  time.Duration
+	cancel context.CancelFunc
+	// 等待客户端答复的工具审批，键为 approvalRequest.ID
+	approvals map[string]chan bool
+}
+
+// approvalRequest 随 state 为 approval 的事件下发，客户端通过 WebSocket 答复
+type approvalRequest struct {
+	ID    string `json:"id"`
+	Tool  string `json:"tool"`
+	Input string `json:"input"`
+}
+
+func newRun(cancel context.CancelFunc, grace time.Duration) *run {
+	return &run{notify: make(chan struct{}), grace: grace, cancel: cancel, approvals: map[string]chan bool{}}
+}
+
+func (rn *run) append(ev event) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+	if rn.done {
+		return
+	}
+	ev.ID = len(rn.events) + 1
+	rn.events = append(rn.events, ev)
+	rn.done = ev.Done
+	close(rn.notify)
+	rn.notify = make(chan struct{})
+	if rn.done && rn.idle != nil {
+		rn.idle.Stop()
+	}
+}
+
+func (rn *run) publish(data SSEData) { rn.append(event{Data: data}) }
+
+func (rn *run) finish() { rn.append(event{Done: true}) }
+
+// approve 发布审批请求并阻塞到任一订阅者答复，或 run 被取消
+func (rn *run) approve(ctx context.Context, tool, input string) bool {
+	req := &approvalRequest{ID: uuid.New().String(), Tool: tool, Input: input}
+	reply := make(chan bool, 1)
+	rn.mu.Lock()
+	rn.approvals[req.ID] = reply
+	rn.mu.Unlock()
+	defer func() {
+		rn.mu.Lock()
+		delete(rn.approvals, req.ID)
+		rn.mu.Unlock()
+	}()
+
+	rn.publish(SSEData{State: stateApproval, Approval: req})
+	select {
+	case ok := <-reply:
+		return ok
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// resolve 答复一个审批请求，请求不存在（已答复或已过期）时返回 false
+func (rn *run) resolve(id string, approved bool) bool {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+	reply, ok := rn.approvals[id]
+	if ok {
+		delete(rn.approvals, id)
+		reply <- approved
+	}
+	return ok
+}
+
+// since 返回 ID 大于 after 的事件、run 是否已结束，以及下一次有新事件时会被关闭的 channel
+func (rn *run) since(after int) ([]event, bool, <-chan struct{}) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+	after = max(0, min(after, len(rn.events)))
+	return rn.events[after:], rn.done, rn.notify
+}
+
+func (rn *run) attach() {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+	rn.subscribers++
+	if rn.idle != nil {
+		rn.idle.Stop()
+		rn.idle = nil
+	}
+}
+
+func (rn *run) detach() {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+	rn.subscribers--
+	if rn.subscribers == 0 && !rn.done {
+		if rn.grace <= 0 {
+			rn.cancel()
+			return
+		}
+		rn.idle = time.AfterFunc(rn.grace, rn.cancel)
+	}
+}
+
+// subscribe 依次把 ID 大于 after 的事件交给 emit，直到 run 结束、ctx 结束或 emit 返回错误
+func (rn *run) subscribe(ctx context.Context, after int, emit func(event) error) error {
+	rn.attach()
+	defer rn.detach()
+	for {
+		evs, done, notify := rn.since(after)
+		for _, ev := range evs {
+			if err := emit(ev); err != nil {
+				return err
+			}
+			after = ev.ID
+		}
+		if done {
+			return nil
+		}
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// serveSSE 以 text/event-stream 的形式把 run 的事件写给客户端。
+// w 不支持 Flush 时不订阅即返回，调用方应在开始一轮之前检查
+func (rn *run) serveSSE(w http.ResponseWriter, r *http.Request, after int) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	srvMetrics.activeStreams.With("sse").Inc()
+	defer srvMetrics.activeStreams.With("sse").Dec()
+	rn.subscribe(r.Context(), after, func(ev event) error {
+		if ev.Done {
+			fmt.Fprintf(w, "id: %d\ndata: [DONE]\n\n", ev.ID)
+		} else {
+			jsonData, _ := json.Marshal(ev.Data)
+			fmt.Fprintf(w, "id: %d\ndata: %s\n\n", ev.ID, jsonData)
+		}
+		flusher.Flush()
+		return r.Context().Err()
+	})
+}
+
+// lastEventID 读取 Last-Event-ID 请求头，原生 EventSource 以外的客户端也可以用 lastEventId 查询参数
+func lastEventID(r *http.Request) int {
+	v := r.Header.Get("Last-Event-ID")
+	if v == "" {
+		v = r.URL.Query().Get("lastEventId")
+	}
+	id, _ := strconv.Atoi(v)
+	return id
+}
+
+var errBusy = errors.New("conversation is busy")
+
+// runErrorStatus 把 startRun 的错误映射为 HTTP 状态码
+func runErrorStatus(err error) int {
+	if errors.Is(err, errTokenQuota) {
+		return http.StatusTooManyRequests
+	}
+	return http.StatusConflict
+}
+
+// startRun 在后台开始会话的新一轮对话，消耗的 token 计入 key 的配额。
+// 会话已有进行中的一轮时返回 errBusy，配额用尽时返回 errTokenQuota。
+// approval 为 true 时每次工具调用都需要客户端审批。resumable 为 true 时订阅者全部断开后
+// 本轮还会运行 runs.resumeGrace 等待重连，否则立即中止。使用 st 的默认模型，并受其 runTimeout 限制
+func startRun(st *setup, conv *conversation, key *apiKey, question string, approval, resumable bool) (*run, error) {
+	if err := key.checkTokens(); err != nil {
+		return nil, err
+	}
+	var usage openai.UsageCounter
+	ctx := openai.WithUsageCounter(key.withUser(context.Background()), &usage)
+	var cancel context.CancelFunc
+	if d := st.runTimeout(); d > 0 {
+		ctx, cancel = context.WithTimeout(ctx, d)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+
+	convMu.Lock()
+	if conv.Status == statusRunning {
+		convMu.Unlock()
+		cancel()
+		return nil, errBusy
+	}
+	history, tools, interrupted := conv.Messages, conv.Tools, conv.Interrupted
+	var grace time.Duration
+	if resumable {
+		grace = st.resumeGrace()
+	}
+	rn := newRun(cancel, grace)
+	conv.Status = statusRunning
+	conv.run = rn
+	convMu.Unlock()
+
+	go func() {
+		defer cancel()
+		msgs, status := history, statusIdle
+		defer func() {
+			key.addTokens(usage.Usage().TotalTokens)
+			if r := recover(); r != nil {
+				slog.Error("run panic", "conversation_id", conv.ID, "panic", r)
+				rn.publish(SSEData{State: "error", Content: fmt.Sprint(r)})
+			}
+			convMu.Lock()
+			conv.Messages = msgs
+			conv.Status = status
+			conv.Interrupted = ""
+			if status == statusCancelled || status == statusTimeout {
+				conv.Interrupted = status
+			}
+			conv.active = time.Now()
+			convMu.Unlock()
+			rn.finish()
+
+			// 超过保留时长后释放本轮的事件，之后无法再续传
+			time.AfterFunc(st.runRetention(), func() {
+				convMu.Lock()
+				defer convMu.Unlock()
+				if conv.run == rn {
+					conv.run = nil
+				}
+			})
+		}()
+
+		// 只传历史，不传当前 user；Iter 内部会追加 user，并按本轮可用的工具重新生成 system prompt
+		opts := []agents.RunOption{
+			agents.WithConversationID(conv.ID),
+			agents.WithTools(tools...),
+			agents.WithoutTools(key.deniedTools()...),
+		}
+		if approval {
+			opts = append(opts, agents.WithApproval(rn.approve))
+		}
+		if note := interruptedNotes[interrupted]; note != "" {
+			opts = append(opts, agents.WithInstructions(note))
+		}
+		events, ch := st.agent("").Stream(ctx, history, question, opts...)
+		for state, ev := range agents.ReactEvents(events) {
+			rn.publish(SSEData{State: state.String(), Content: ev.Text, Depth: ev.Depth, Agent: ev.Agent})
+		}
+		res := <-ch
+		msgs, status = res.Messages, statusDone
+		if res.Err != nil {
+			slog.Error("run failed", "conversation_id", conv.ID, "stop_reason", res.Stop, "error", res.Err)
+			rn.publish(SSEData{State: "error", Content: res.Err.Error()})
+		}
+		if res.Stop == agents.StopCancelled {
+			status = statusCancelled
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				status = statusTimeout
+			}
+			rn.publish(SSEData{State: status})
+		}
+	}()
+	return rn, nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/eastlaugh/agent/pkg/trace"
+)
+
+// newTracer 按 -trace 参数创建 tracer：空为不追踪，stdout 为逐行打印，
+// http(s) 地址为 OTLP/HTTP 端点，其余视为 OTLP/JSON 文件路径（每行一批）
+func newTracer(spec string) (*trace.Tracer, error) {
+	const service = "agent-server"
+	switch {
+	case spec == "":
+		return nil, nil
+	case spec == "stdout":
+		return trace.NewTracer(trace.NewWriterExporter(os.Stdout)), nil
+	case strings.HasPrefix(spec, "http://"), strings.HasPrefix(spec, "https://"):
+		exp := trace.NewOTLPHTTPExporter(spec, service)
+		exp.OnError = func(err error) { slog.Warn("trace export failed", "error", err) }
+		return trace.NewTracer(exp), nil
+	default:
+		f, err := os.OpenFile(spec, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		exp := trace.NewOTLPFileExporter(f, service)
+		exp.OnError = func(err error) { slog.Warn("trace export failed", "error", err) }
+		return trace.NewTracer(exp), nil
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/eastlaugh/agent/pkg/websocket"
+)
+
+// 心跳间隔；超过两个间隔收不到任何数据（含 pong）即认为连接已断开
+const wsPingInterval = 30 * time.Second
+
+// wsInbound 是客户端发来的消息，Type 为 question、approval、cancel 或 resume
+type wsInbound struct {
+	Type           string `json:"type"`
+	ConversationId string `json:"conversationId"`
+	Question       string `json:"question,omitempty"`
+	// question：为 true 时每次工具调用都需要客户端发送 approval 确认
+	RequireApproval bool `json:"requireApproval,omitempty"`
+	// question：为 true 时连接断开后本轮继续运行 runs.resumeGrace，可在新连接上 resume
+	Resumable bool `json:"resumable,omitempty"`
+	// approval：对应事件中 approval.id
+	ApprovalId string `json:"approvalId,omitempty"`
+	Approved   bool   `json:"approved,omitempty"`
+	// resume：从该事件 ID 之后继续推送
+	LastEventId int `json:"lastEventId,omitempty"`
+}
+
+// wsOutbound 是发给客户端的消息。Type 为 event 时携带与 SSE 相同的 SSEData，
+// 为 done 时表示该会话本轮结束，为 error 时 Error 描述原因
+type wsOutbound struct {
+	Type           string `json:"type"`
+	ConversationId string `json:"conversationId,omitempty"`
+	EventId        int    `json:"eventId,omitempty"`
+	*SSEData
+	Error string `json:"error,omitempty"`
+}
+
+type wsSession struct {
+	key  *apiKey
+	conn *websocket.Conn
+	ctx  context.Context
+	wg   sync.WaitGroup
+}
+
+func (s *wsSession) send(msg wsOutbound) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return s.conn.WriteMessage(websocket.OpText, data)
+}
+
+func (s *wsSession) sendError(conversationId, msg string) {
+	s.send(wsOutbound{Type: "error", ConversationId: conversationId, Error: msg})
+}
+
+// forward 把 run 的事件推送给客户端，直到 run 结束或连接关闭
+func (s *wsSession) forward(conversationId string, rn *run, after int) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		rn.subscribe(s.ctx, after, func(ev event) error {
+			if ev.Done {
+				return s.send(wsOutbound{Type: "done", ConversationId: conversationId, EventId: ev.ID})
+			}
+			return s.send(wsOutbound{Type: "event", ConversationId: conversationId, EventId: ev.ID, SSEData: &ev.Data})
+		})
+	}()
+}
+
+func (s *wsSession) handle(msg wsInbound) {
+	if msg.ConversationId == "" {
+		s.sendError("", "conversationId required")
+		return
+	}
+	ctx := context.WithValue(s.ctx, apiKeyCtxKey{}, s.key)
+	conv := getConversation(ctx, msg.ConversationId, msg.Type == "question")
+	if conv == nil {
+		s.sendError(msg.ConversationId, "not found")
+		return
+	}
+	convMu.Lock()
+	rn := conv.run
+	convMu.Unlock()
+
+	switch msg.Type {
+	case "question":
+		// 同一连接上的每次提问都计入每分钟请求数
+		if ok, _ := s.key.allowRequest(); !ok {
+			s.sendError(msg.ConversationId, "rate limit exceeded")
+			return
+		}
+		st := current()
+		if err := st.checkQuestion(msg.Question); err != nil {
+			s.sendError(msg.ConversationId, err.Error())
+			return
+		}
+		rn, err := startRun(st, conv, s.key, msg.Question, msg.RequireApproval, msg.Resumable)
+		if err != nil {
+			s.sendError(msg.ConversationId, err.Error())
+			return
+		}
+		s.forward(msg.ConversationId, rn, 0)
+	case "resume":
+		if rn == nil {
+			s.sendError(msg.ConversationId, "no run to resume")
+			return
+		}
+		s.forward(msg.ConversationId, rn, msg.LastEventId)
+	case "approval":
+		if rn == nil || !rn.resolve(msg.ApprovalId, msg.Approved) {
+			s.sendError(msg.ConversationId, "no pending approval "+msg.ApprovalId)
+		}
+	case "cancel":
+		if rn == nil {
+			s.sendError(msg.ConversationId, "no running turn")
+			return
+		}
+		rn.cancel()
+	default:
+		s.sendError(msg.ConversationId, "unknown message type "+msg.Type)
+	}
+}
+
+// handleWebSocket 实现 GET /api/ws：双向会话，客户端发送提问、审批与取消，
+// 服务端推送与 SSE 相同的 state/content 事件。
+// 浏览器的 WebSocket 不受 CORS 限制，握手前按 allowedOrigins 校验 Origin，防止其他网站借用户的 token 建立连接
+func handleWebSocket(au *auth) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !au.originAllowed(r.Header.Get("Origin")) {
+			http.Error(w, "origin not allowed", http.StatusForbidden)
+			return
+		}
+		conn, err := websocket.Upgrade(w, r)
+		if err != nil {
+			slog.Warn("websocket", "error", err)
+			return
+		}
+		srvMetrics.activeStreams.With("websocket").Inc()
+		defer srvMetrics.activeStreams.With("websocket").Dec()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		s := &wsSession{key: keyFromContext(r.Context()), conn: conn, ctx: ctx}
+		defer func() {
+			// 先停止推送，再关闭连接；进行中的 run 若以 resumable 发起则继续运行，可稍后 resume，否则随之中止
+			cancel()
+			s.wg.Wait()
+			conn.Close(websocket.CloseNormal, "")
+		}()
+
+		deadline := func() { conn.SetReadDeadline(time.Now().Add(2 * wsPingInterval)) }
+		deadline()
+		conn.PongHandler = func([]byte) { deadline() }
+
+		go func() {
+			tk := time.NewTicker(wsPingInterval)
+			defer tk.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-tk.C:
+					if err := conn.WriteControl(websocket.OpPing, nil); err != nil {
+						return
+					}
+				}
+			}
+		}()
+
+		for {
+			op, data, err := conn.ReadMessage()
+			if err != nil {
+				var ce *websocket.CloseError
+				if !errors.As(err, &ce) {
+					slog.Warn("websocket", "error", err)
+				}
+				return
+			}
+			deadline()
+			if op != websocket.OpText {
+				s.sendError("", "expected text message")
+				continue
+			}
+			var msg wsInbound
+			if err := json.Unmarshal(data, &msg); err != nil {
+				s.sendError("", err.Error())
+				continue
+			}
+			s.handle(msg)
+		}
+	}
+}
@@ -1,71 +1,103 @@
 package agents
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"iter"
-	"log"
+	"log/slog"
 	"reflect"
+	"slices"
 	"strings"
+	"time"
+	"unicode/utf8"
 
+	"github.com/eastlaugh/agent/pkg/logging"
 	"github.com/eastlaugh/agent/pkg/openai"
+	"github.com/eastlaugh/agent/pkg/trace"
 	"github.com/eastlaugh/agent/pkg/util"
+	"github.com/google/uuid"
 )
 
+// Handler 直接处理模型给出的原始“动作输入”，用于参数不来自 Go 函数签名的工具，例如 MCP 工具。
+// 返回的 error 会作为失败的观察交给模型
+type Handler func(ctx context.Context, input string) (string, error)
+
 type tool struct {
 	Name        string
 	Description string
 	Func        any
+	// Handler 不为 nil 时代替 Func 执行
+	Handler Handler
+	// Params 在系统提示词中跟在工具名之后描述参数，为空时由 Func 的签名生成
+	Params string
+	// 模型使用这些名称时同样调用该工具
+	Aliases []string
+	// Sensitive 为 true 时日志中不记录参数与输出
+	Sensitive bool
+	// 大于 0 时成功的输出在这段时间内被缓存，见 Cache
+	CacheTTL time.Duration
+	// 该工具专用的中间件，见 Middleware
+	Middlewares []ToolMiddleware
 }
 
-func (t *tool) Run(input string) (output string) {
-	defer func() {
-		if r := recover(); r != nil {
-			output = fmt.Sprintf("工具 %s 执行时发生恐慌: %v", t.Name, r)
-			log.Println(output)
+// call 执行工具本身，恐慌由 Recovery 处理
+func (t tool) call(ctx context.Context, req ToolRequest) ToolResult {
+	if t.Handler != nil {
+		output, err := t.Handler(ctx, req.Input)
+		if err != nil {
+			return ToolResult{Output: fmt.Sprintf("工具 %s 执行出错: %v", t.Name, err), Failed: true}
 		}
-	}()
+		return ToolResult{Output: nonEmpty(output)}
+	}
+	output, args := util.CallFunc(t.Func, req.Input)
+	return ToolResult{Output: nonEmpty(output), args: args}
+}
 
-	output, args := util.CallFunc(t.Func, input)
+func nonEmpty(output string) string {
 	output = strings.TrimSpace(output)
 	if output == "" {
 		panic("tool returned empty string")
 	}
-
-	log.Print(util.FormatToolLog(t.Name, t.Description, t.Func, args, output))
 	return output
 }
 
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n] + "…"
+}
+
 type Agent struct {
 	client   Client
-	tools    map[string]tool
+	tools    *Registry
+	name     string
 	maxSteps int
 	prompter func(string) string
+	metrics  Metrics
+	format   FormatPolicy
+	// 为空时不保存检查点，见 WithCheckpoints
+	checkpoints CheckpointStore
+	toolCache   CacheStore
+	middlewares []ToolMiddleware
+	tracer      *trace.Tracer
+	logger      *slog.Logger
 }
 
 type Client interface {
-	Chat(messages []openai.Message, stop []string) (string, error)
-	ChatStream(messages []openai.Message, stop []string) (iter.Seq[string], error)
+	Chat(ctx context.Context, messages []openai.Message, stop []string) (string, error)
+	ChatStream(ctx context.Context, messages []openai.Message, stop []string) (iter.Seq[string], error)
 }
 
-// New 创建一个新的 ReAct Agent，Prompt 经由 Prompter 包装，args 为多个工具，以 Func, Desc (string) 配对传入
-// 如果工具中需要包含 Agent 自身，像这样:
-//
-//	var agt *agents.Agent
-//	agt = agents.New(client, nil,
-//		rand.IntN, "生成随机数",
-//		agt.AsTool(), "...",
-//	)
+// New 创建一个新的 ReAct Agent，Prompt 经由 Prompter 包装，args 为多个工具，以 Func, Desc (string) 配对传入。
+// 其他 Agent 可以经由 AsTool 作为工具传入，见 AsTool。
+// 工具名取自函数名（如 math/rand/v2.IntN），需要简短稳定的名称时请改用 NewWithRegistry
 func New(client Client, prompter func(string) string, args ...any) *Agent {
-	if prompter == nil {
-		prompter = func(prompt string) string { return prompt }
-	}
-
-	var agent = &Agent{
-		client:   client,
-		tools:    make(map[string]tool),
-		maxSteps: 10,
-		prompter: prompter,
-	}
+	var agent = NewWithRegistry(client, prompter, NewRegistry())
 
 	for i := 0; i < len(args); i += 2 {
 		if i+1 >= len(args) {
@@ -84,17 +116,42 @@ func New(client Client, prompter func(string) string, args ...any) *Agent {
 	return agent
 }
 
-func (a *Agent) SystemPrompt() (prompt string) {
+// NewWithRegistry 创建一个使用 reg 中工具的 Agent，reg 之后的增删会反映到后续运行中
+//
+//	reg := agents.NewRegistry()
+//	reg.Namespace("web").
+//		MustRegister("search", tools.SearchInternet, "在互联网上搜索信息").
+//		MustRegister("get", tools.HttpGet, "发送 HTTP GET 请求", agents.Alias("http_get"))
+//	agt := agents.NewWithRegistry(client, nil, reg)
+func NewWithRegistry(client Client, prompter func(string) string, reg *Registry) *Agent {
+	if prompter == nil {
+		prompter = func(prompt string) string { return prompt }
+	}
+	return &Agent{
+		client:   client,
+		tools:    reg,
+		maxSteps: 10,
+		prompter: prompter,
+		format:   DefaultFormatPolicy,
+		logger:   slog.Default(),
+	}
+}
+
+// Tools 返回 Agent 使用的工具注册表
+func (a *Agent) Tools() *Registry {
+	return a.tools
+}
+
+// SystemPrompt 返回包含全部工具的系统提示词；单次运行的提示词只包含该次可用的工具
+func (a *Agent) SystemPrompt() string {
+	return a.systemPrompt(a.tools)
+}
+
+func (a *Agent) systemPrompt(tools *Registry) (prompt string) {
 	defer func() {
 		prompt = a.prompter(prompt)
 	}()
-	var toolDescriptions strings.Builder
-	var toolNames []string
-
-	for name, tool := range a.tools {
-		fmt.Fprintf(&toolDescriptions, "// %s\n%s%s\n ", tool.Description, name, util.MarshalFunc(tool.Func))
-		toolNames = append(toolNames, name)
-	}
+	toolDescriptions, toolNames := describeTools(tools)
 	return fmt.Sprintf(`你是一个 ReAct Agent，尽可能回答以下问题。你可以使用以下工具：
 
 %s
@@ -109,118 +166,512 @@ func (a *Agent) SystemPrompt() (prompt string) {
 思考：我现在知道最终答案了
 最终答案：原始输入问题的最终答案
 
-开始！`, toolDescriptions.String(), toolNames)
+开始！`, toolDescriptions, toolNames)
+}
+
+// describeTools 返回系统提示词中的工具说明与工具名列表
+func describeTools(tools *Registry) (string, []string) {
+	var toolDescriptions strings.Builder
+	var toolNames []string
+
+	for _, tool := range tools.list() {
+		desc := tool.Description
+		if len(tool.Aliases) > 0 {
+			desc += fmt.Sprintf("（别名：%s）", strings.Join(tool.Aliases, ", "))
+		}
+		params := tool.Params
+		if params == "" && tool.Handler != nil {
+			// Handler 收到完整的动作输入
+			params = "(string)"
+		} else if params == "" {
+			params = util.MarshalFunc(tool.Func)
+		}
+		fmt.Fprintf(&toolDescriptions, "// %s\n%s%s\n ", desc, tool.Name, params)
+		toolNames = append(toolNames, tool.Name)
+	}
+	return toolDescriptions.String(), toolNames
+}
+
+// RunOption 调整单次 Iter 运行的行为
+type RunOption func(*runConfig)
+
+type runConfig struct {
+	approve        func(ctx context.Context, tool, input string) bool
+	conversationID string
+	tools          []string
+	withoutTools   []string
+	instructions   []string
+	// 大于 0 时代替 Agent 的 maxSteps，供子 Agent 使用
+	maxSteps int
+	// 本次运行额外可用的工具，例如 Router 的转交工具
+	extraTools []tool
+	// 每次观察之后调用，返回 true 时运行以 handoff 结束，供 Router 转交
+	halt func() bool
+	// 以“计划-执行”模式运行，见 WithPlanning
+	planning bool
+	// 大于 0 时本次运行是计划的第 planStep 步
+	planStep int
+	// 最终答案的检查，见 WithCritic 与 RunTyped
+	checks []answerCheck
+	// RunTyped 的重试次数，nil 表示默认值
+	typedRetries *int
+	// 检查点的 ID，见 WithCheckpointID
+	checkpointID string
+}
+
+// WithTools 使本次运行只能使用 names 所指的工具，names 可以是工具名、别名或命名空间（如 web）。
+// 名称不存在时 IterContext 会 panic
+func WithTools(names ...string) RunOption {
+	return func(c *runConfig) { c.tools = append(c.tools, names...) }
+}
+
+// WithoutTools 禁止本次运行使用 names 所指的工具，例如 WithoutTools("web") 禁止联网。
+// 与 WithTools 同时使用时先取子集再排除
+func WithoutTools(names ...string) RunOption {
+	return func(c *runConfig) { c.withoutTools = append(c.withoutTools, names...) }
+}
+
+// WithInstructions 在本次运行的系统提示词之后追加额外的指示，例如来自客户端的 system 消息
+func WithInstructions(text ...string) RunOption {
+	return func(c *runConfig) { c.instructions = append(c.instructions, text...) }
+}
+
+// WithConversationID 把会话 ID 作为 conversation_id 属性附加到本次运行的所有日志上
+func WithConversationID(id string) RunOption {
+	return func(c *runConfig) { c.conversationID = id }
+}
+
+// WithApproval 在每次执行工具前征求 approve 的同意，返回 false 时不执行该工具，
+// 而是把“用户拒绝”作为观察交给模型。approve 可以阻塞等待用户操作，ctx 结束时应尽快返回
+func WithApproval(approve func(ctx context.Context, tool, input string) bool) RunOption {
+	return func(c *runConfig) { c.approve = approve }
 }
 
 // 当 Tool 返回观察结果时，该 Message 的 Role 使用 "system"，这是一种反模式。一些 AI Provider，如deepseek，可能会导致预期的行为
 // 为了兼容 Open AI API，仍然使用 user 作为观察的 role
 const X = "user"
 
-func (a *Agent) Iter(messages []openai.Message, question string) (iter.Seq[string], <-chan []openai.Message) {
+func (a *Agent) Iter(messages []openai.Message, question string) (iter.Seq[string], <-chan Transcript) {
+	return a.IterContext(context.Background(), messages, question)
+}
 
-	if len(messages) == 0 {
-		sysPrompt := a.SystemPrompt()
-		log.Printf("[SystemPrompt]\n%s", sysPrompt)
-		messages = []openai.Message{
-			{Role: "system", Content: sysPrompt},
+// IterContext 与 Iter 相同，但在 ctx 结束时停止运行：正在进行的模型请求会被中止，
+// 截至目前的消息（包括未完成的 assistant 回复）仍会通过 ch 交付，便于调用方保存。
+// 消费者提前停止、模型请求失败或超过最大步数时同样如此，结束原因见 Transcript。
+//
+// 每次运行开始时取一次工具快照，并据此重新生成系统提示词：messages 为空时插入，
+// 以 system 消息开头时替换该消息，因此历史中的提示词总是与本次实际可用的工具一致。
+//
+// 迭代器只产出本 Agent 的输出；需要同时看到子 Agent 的步骤时使用 Stream
+func (a *Agent) IterContext(ctx context.Context, messages []openai.Message, question string, opts ...RunOption) (iter.Seq[string], <-chan Transcript) {
+	depth := runDepth(ctx)
+	events, ch := a.Stream(ctx, messages, question, opts...)
+	return func(yield func(string) bool) {
+		for ev := range events {
+			if ev.Depth == depth && !yield(ev.Text) {
+				return
+			}
 		}
+	}, ch
+}
+
+// Event 是 Stream 产出的一段输出
+type Event struct {
+	// Depth 为 0 表示本 Agent 的输出，子 Agent 的输出每嵌套一层加一
+	Depth int
+	// Agent 为产出该段输出的 Agent 的名称，见 WithName
+	Agent string
+	// Text 是原始文本片段，与 IterContext 产出的相同，可交给 ReactEvents 解析
+	Text string
+	// Cached 为 true 表示这是一条来自工具缓存的观察，见 WithToolCache
+	Cached bool
+}
+
+// StopReason 说明一次运行为什么结束
+type StopReason string
+
+const (
+	StopFinalAnswer StopReason = "final_answer"
+	// StopHandoff 表示 Router 中的 Agent 把对话转交给了其他 Agent
+	StopHandoff StopReason = "handoff"
+	// StopConsumer 表示消费者提前停止了迭代
+	StopConsumer  StopReason = "consumer_stopped"
+	StopCancelled StopReason = "cancelled"
+	// StopError 表示模型请求失败，或运行中发生了工具以外的恐慌
+	StopError           StopReason = "error"
+	StopMaxSteps        StopReason = "max_steps"
+	StopFormatViolation StopReason = "format_violation"
+)
+
+var (
+	ErrMaxSteps        = errors.New("达到最大步数仍未找到最终答案")
+	ErrFormatViolation = errors.New("模型多次未遵循 ReAct 格式")
+)
+
+// Transcript 是一次运行结束时经由 channel 交付的结果。无论运行如何结束都会交付，
+// Messages 为截至结束时的历史，包括消费者已经看到的部分回复
+type Transcript struct {
+	Messages []openai.Message
+	Stop     StopReason
+	// Err 在 Stop 为 StopError、StopMaxSteps 或 StopFormatViolation 时不为 nil
+	Err error
+}
+
+// Answer 返回被采纳的最终答案，即最后一条 assistant 消息中的最终答案，没有时返回空字符串。
+// 事件流中被 WithCritic 或 RunTyped 拒绝的答案不包括在内
+func (t Transcript) Answer() string {
+	return lastAnswer(t.Messages)
+}
+
+// lastAnswer 返回 messages 末尾 assistant 消息中的最终答案
+func lastAnswer(messages []openai.Message) string {
+	if n := len(messages); n > 0 && messages[n-1].Role == "assistant" {
+		answer, _ := finalAnswer(messages[n-1].Content)
+		return answer
+	}
+	return ""
+}
+
+// Stream 与 IterContext 相同，但产出的是事件：作为工具运行的子 Agent（见 AsTool）的每一步
+// 都会按嵌套深度标记后插入父 Agent 的事件流，位置在对应的“动作输入”与“观察”之间
+func (a *Agent) Stream(ctx context.Context, messages []openai.Message, question string, opts ...RunOption) (iter.Seq[Event], <-chan Transcript) {
+	var cfg runConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.planning {
+		return a.planAndExecute(ctx, messages, question, cfg, opts)
 	}
-	messages = append(messages, openai.Message{Role: "user", Content: question})
 
-	var ch = make(chan []openai.Message, 1)
+	cp := Checkpoint{ConversationID: cfg.conversationID, Question: question}
+	if a.checkpoints != nil && runDepth(ctx) == 0 && cfg.planStep == 0 {
+		cp.ID = cfg.checkpointID
+		if cp.ID == "" {
+			cp.ID = uuid.NewString()
+		}
+		ctx = logging.WithAttrs(ctx, slog.String("checkpoint_id", cp.ID))
+	}
+	ctx = a.withRunAttrs(ctx, cfg)
+	tools := a.runTools(cfg)
+	cp.Messages = a.runMessages(ctx, tools, cfg, messages, question)
+	return a.run(ctx, cfg, tools, cp)
+}
+
+// run 从 cp 所记录的状态开始 ReAct 循环：cp.Messages 已包含系统提示词，cp.Pending 不为空时先执行该工具调用。
+// cp.ID 不为空时在每一步之后保存检查点，运行正常结束后删除
+func (a *Agent) run(ctx context.Context, cfg runConfig, tools *Registry, cp Checkpoint) (iter.Seq[Event], <-chan Transcript) {
+	depth := runDepth(ctx)
+	maxSteps := a.maxSteps
+	if cfg.maxSteps > 0 {
+		maxSteps = cfg.maxSteps
+	}
+	messages, question := cp.Messages, cp.Question
+
+	var ch = make(chan Transcript, 1)
 	var consumed bool
-	return func(yield func(string) bool) {
+	return func(yield func(Event) bool) {
 		if consumed {
 			panic("agents: consumed iterator")
 		}
 		consumed = true
 
-		// 首次消费迭代器
-		defer close(ch)
-		var step int
-		for {
-			if step > a.maxSteps {
-				panic("达到最大步数仍未找到最终答案")
+		// 子 Agent 经由 ctx 中的 emit 向同一个 yield 输出；消费者停止后不能再调用 yield
+		var stopped bool
+		emit := func(ev Event) bool {
+			if stopped || !yield(ev) {
+				stopped = true
+				return false
+			}
+			return true
+		}
+		text := func(s string) bool { return emit(Event{Depth: depth, Agent: a.name, Text: s}) }
+
+		step, pending := cp.Step, cp.Pending
+		if a.metrics != nil {
+			defer func() { a.metrics.RunDone(step) }()
+		}
+
+		// run → step → LLM 调用 / 工具调用
+		// 各项答案检查已拒绝的次数
+		rejections := make([]int, len(cfg.checks))
+		var revisions int
+		// 格式错误的重试次数，以及 DropFailed 时待删去的失败尝试 messages[failedFrom:failedTo]；
+		// 只在模型恢复正确格式后删去，其他方式结束时保留，使交付的历史能说明结束的原因
+		var formatRetries int
+		var failedFrom, failedTo = -1, -1
+		dropFailed := func() {
+			if a.format.DropFailed && failedFrom >= 0 {
+				messages = slices.Delete(messages, failedFrom, failedTo)
+				failedFrom, failedTo = -1, -1
+			}
+		}
+		// 无论以何种方式结束都交付截至目前的历史；工具以外的恐慌（如 approve 回调）交付后继续传播
+		var stopReason StopReason = "aborted"
+		var runErr error
+		defer func() {
+			r := recover()
+			if r != nil {
+				stopReason, runErr = StopError, fmt.Errorf("agents: panic: %v", r)
+			}
+			ch <- Transcript{Messages: messages, Stop: stopReason, Err: runErr}
+			close(ch)
+			if r != nil {
+				panic(r)
+			}
+		}()
+		// 保存检查点，call 为已解析但尚未得到观察的工具调用；保存失败不影响运行
+		checkpoint := func(call *ToolCall) {
+			if cp.ID == "" {
+				return
 			}
+			cp.Messages, cp.Step, cp.Pending, cp.Time = slices.Clone(messages), step, call, time.Now()
+			if err := a.checkpoints.Save(ctx, &cp); err != nil {
+				a.logger.WarnContext(ctx, "checkpoint failed", slog.Any("error", err))
+			}
+		}
+		finished := func() {
+			if cp.ID == "" {
+				return
+			}
+			if err := a.checkpoints.Delete(ctx, cp.ID); err != nil {
+				a.logger.WarnContext(ctx, "checkpoint cleanup failed", slog.Any("error", err))
+			}
+		}
+		ctx, runSpan := a.tracer.Start(ctx, "agent.run", trace.String("agent.question", question))
+		var stepSpan *trace.Span
+		defer func() {
+			stepSpan.End()
+			runSpan.SetAttrs(trace.Int("agent.steps", step), trace.Int("agent.revisions", revisions), trace.String("agent.stop_reason", string(stopReason)))
+			switch stopReason {
+			case StopFinalAnswer, StopConsumer, StopHandoff:
+				runSpan.SetStatus(trace.StatusOK, "")
+			default:
+				runSpan.SetStatus(trace.StatusError, string(stopReason))
+			}
+			runSpan.End()
+		}()
 
-			iter, err := a.client.ChatStream(messages, []string{"观察："})
-			if err != nil {
-				panic(err)
+		for {
+			stepSpan.End()
+			if ctx.Err() != nil {
+				stopReason = StopCancelled
+				return
 			}
+			if step > maxSteps {
+				stopReason, runErr = StopMaxSteps, ErrMaxSteps
+				return
+			}
+
+			var stepCtx context.Context
+			stepCtx, stepSpan = trace.Start(ctx, "agent.step", trace.Int("agent.step", step))
 
-			var response strings.Builder
-			for chunk := range iter {
-				response.WriteString(chunk)
-				if !yield(chunk) {
+			// 从检查点恢复时先执行中断前未完成的工具调用
+			var toolName, toolInput string
+			if pending != nil {
+				toolName, toolInput, pending = pending.Tool, pending.Input, nil
+			} else {
+				iter, err := a.client.ChatStream(stepCtx, messages, []string{"观察："})
+				if err != nil {
+					if ctx.Err() != nil {
+						stopReason = StopCancelled
+						return
+					}
+					stopReason, runErr = StopError, err
+					stepSpan.RecordError(err)
+					a.logger.ErrorContext(ctx, "llm request failed", slog.Any("error", err))
 					return
 				}
-			}
 
-			Text := response.String()
+				var response strings.Builder
+				for chunk := range iter {
+					response.WriteString(chunk)
+					if !text(chunk) {
+						// 保留消费者已经看到的部分回复
+						messages = append(messages, openai.Message{Role: "assistant", Content: response.String()})
+						stopReason = StopConsumer
+						return
+					}
+				}
 
-			// 将 Agent 的回复添加到历史记录
-			messages = append(messages, openai.Message{Role: "assistant", Content: Text})
+				Text := response.String()
 
-			// 最终答案
-			if match := finalAnswerRegex.FindStringSubmatch(Text); match != nil {
-				ch <- messages
-				return
-			}
+				// 被取消时保留已生成的部分回复
+				if ctx.Err() != nil {
+					if Text != "" {
+						messages = append(messages, openai.Message{Role: "assistant", Content: Text})
+					}
+					stopReason = StopCancelled
+					return
+				}
 
-			// 解析动作
-			match := actionRegex.FindStringSubmatch(Text)
-			if match == nil {
-				messages = append(messages, openai.Message{Role: X, Content: "你没有遵循ReAct。你没有输出最终答案，也没有输出动作。请严格按照 ReAct 格式进行。上一条消息将被忽略。Continue!"})
-				continue
-			}
+				// 不含任何标记的回复按策略视为最终答案，补充产出标记使消费者得到 Answering 状态
+				if a.format.PlainAnswer && plainReply(Text) {
+					Text = "最终答案：" + strings.TrimSpace(Text)
+					if !text("\n" + Text) {
+						messages = append(messages, openai.Message{Role: "assistant", Content: Text})
+						stopReason = StopConsumer
+						return
+					}
+				}
 
-			toolName := strings.TrimSpace(match[1])
-			toolInput := strings.TrimSpace(match[2])
+				// 将 Agent 的回复添加到历史记录
+				messages = append(messages, openai.Message{Role: "assistant", Content: Text})
+
+				// 最终答案，未通过检查时附上意见继续循环
+				if match := finalAnswerRegex.FindStringSubmatch(Text); match != nil {
+					dropFailed()
+					answer, _ := finalAnswer(Text)
+					if feedback := a.reviewAnswer(stepCtx, cfg.checks, rejections, messages, answer); feedback != "" {
+						revisions++
+						stepSpan.SetAttrs(trace.Bool("agent.answer_rejected", true))
+						messages = append(messages, openai.Message{Role: X, Content: feedback + "\n请根据以上意见修正，继续按 ReAct 格式回答。"})
+						if !text(feedback + "\n") {
+							stopReason = StopConsumer
+							return
+						}
+						continue
+					}
+					stopReason = StopFinalAnswer
+					finished()
+					return
+				}
+
+				// 解析动作
+				match := actionRegex.FindStringSubmatch(Text)
+				if match == nil {
+					if a.metrics != nil {
+						a.metrics.FormatViolation()
+					}
+					stepSpan.SetAttrs(trace.Bool("agent.format_violation", true))
+					if formatRetries >= a.format.MaxRetries {
+						stopReason, runErr = StopFormatViolation, ErrFormatViolation
+						return
+					}
+					formatRetries++
+					if failedFrom < 0 {
+						failedFrom = len(messages) - 1
+					}
+					messages = append(messages, openai.Message{Role: X, Content: formatReminder})
+					failedTo = len(messages)
+					continue
+				}
+				dropFailed()
+
+				toolName = strings.TrimSpace(match[1])
+				toolInput = strings.TrimSpace(match[2])
+				checkpoint(&ToolCall{Tool: toolName, Input: toolInput})
+			}
 
-			tool, ok := a.tools[toolName]
+			tool, ok := tools.lookup(toolName)
+			if ok {
+				toolName = tool.Name
+			}
 			var observation string
+			var cached bool
 			if !ok {
-				observation = fmt.Sprintf("错误：找不到工具 '%s'。可用工具：%v", toolName, a.tools)
+				observation = fmt.Sprintf("错误：找不到工具 '%s'。可用工具：%v", toolName, tools.Names())
+			} else if cfg.approve != nil && !cfg.approve(ctx, toolName, toolInput) {
+				observation = fmt.Sprintf("用户拒绝执行工具 '%s'，请尝试其他方式或直接回答", toolName)
 			} else {
-				observation = tool.Run(toolInput)
+				toolCtx, toolSpan := trace.Start(stepCtx, "agent.tool",
+					trace.String("tool.name", toolName),
+					trace.String("tool.input", toolInput),
+				)
+				res := a.toolChain(tool)(withEmitter(toolCtx, emit), ToolRequest{Tool: tool.info(), Input: toolInput})
+				observation, cached = res.Output, res.Cached
+				toolSpan.SetAttrs(trace.Int("tool.output_bytes", len(observation)), trace.Bool("tool.failed", res.Failed), trace.Bool("tool.cached", cached))
+				if res.Failed {
+					toolSpan.SetStatus(trace.StatusError, observation)
+				}
+				toolSpan.End()
+				if stopped {
+					stopReason = StopConsumer
+					return
+				}
 			}
 
 			// 观察
 			obsMsg := fmt.Sprintf("观察：%s", observation)
 			messages = append(messages, openai.Message{Role: X, Content: obsMsg})
-			if !yield(obsMsg + "\n") {
+			if !emit(Event{Depth: depth, Agent: a.name, Text: obsMsg + "\n", Cached: cached}) {
+				stopReason = StopConsumer
 				return
 			}
 
 			step++
+			checkpoint(nil)
+			if cfg.halt != nil && cfg.halt() {
+				stopReason = StopHandoff
+				finished()
+				return
+			}
 		}
 	}, ch
 
 }
 
-func (a *Agent) AsTool() func(string) string {
-	return func(input string) string {
-		it, _ := a.Iter(nil, input)
-		var result strings.Builder
-		for chunk := range it {
-			result.WriteString(chunk)
+// withRunAttrs 为本次运行的日志带上 run_id 与 conversation_id，包括 Client 经由 ctx 输出的日志；
+// 子 Agent 的运行保留父运行的 run_id，另带 sub_run_id 与 depth，计划的每一步另带 plan_step
+func (a *Agent) withRunAttrs(ctx context.Context, cfg runConfig) context.Context {
+	if cfg.planStep > 0 {
+		return logging.WithAttrs(ctx, slog.Int("plan_step", cfg.planStep))
+	}
+	depth := runDepth(ctx)
+	id := uuid.NewString()
+	attrs := []slog.Attr{slog.String("run_id", id)}
+	if depth > 0 {
+		attrs = []slog.Attr{slog.String("sub_run_id", id), slog.Int("depth", depth)}
+	}
+	if a.name != "" {
+		attrs = append(attrs, slog.String("agent", a.name))
+	}
+	if cfg.conversationID != "" {
+		attrs = append(attrs, slog.String("conversation_id", cfg.conversationID))
+	}
+	return logging.WithAttrs(ctx, attrs...)
+}
+
+// runTools 返回本次运行可用工具的快照
+func (a *Agent) runTools(cfg runConfig) *Registry {
+	tools := a.tools
+	if len(cfg.tools) > 0 {
+		var err error
+		if tools, err = tools.Subset(cfg.tools...); err != nil {
+			panic(err)
+		}
+	}
+	tools = tools.Without(cfg.withoutTools...)
+	for _, t := range cfg.extraTools {
+		if err := tools.add(t); err != nil {
+			panic(err)
 		}
-		return result.String()
 	}
+	return tools
+}
+
+// runMessages 按本次可用的工具替换或插入系统提示词，并追加问题
+func (a *Agent) runMessages(ctx context.Context, tools *Registry, cfg runConfig, messages []openai.Message, question string) []openai.Message {
+	sysPrompt := strings.Join(append([]string{a.systemPrompt(tools)}, cfg.instructions...), "\n\n")
+	a.logger.DebugContext(ctx, "system prompt", slog.String("prompt", sysPrompt))
+	sysMsg := openai.Message{Role: "system", Content: sysPrompt}
+	if len(messages) > 0 && messages[0].Role == "system" {
+		messages = append([]openai.Message{sysMsg}, messages[1:]...)
+	} else {
+		messages = append([]openai.Message{sysMsg}, messages...)
+	}
+	return append(messages, openai.Message{Role: "user", Content: question})
 }
 
 func (agt *Agent) add(fn any, desc string) {
 	if reflect.TypeOf(fn).Kind() != reflect.Func {
 		panic("agents: invalid func")
 	}
-
 	var name = util.GetFuncName(fn, false)
-	if _, ok := agt.tools[name]; ok {
-		panic("agents: redundant tool definition")
+	t := tool{Name: name, Description: desc, Func: fn}
+	if h, ok := fn.(Handler); ok {
+		t = tool{Name: name, Description: desc, Handler: h}
 	}
-	agt.tools[name] = tool{
-		Name:        name,
-		Description: desc,
-		Func:        fn,
+	if err := agt.tools.add(t); err != nil {
+		panic(err)
 	}
 }
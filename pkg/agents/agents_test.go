@@ -1,8 +1,13 @@
 package agents
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"iter"
 	"testing"
+
+	"github.com/eastlaugh/agent/pkg/openai"
 )
 
 func Test(t *testing.T) {
@@ -18,3 +23,84 @@ func Test(t *testing.T) {
 	println(agt.SystemPrompt())
 
 }
+
+// fakeClient 按顺序返回预设的回复，每个回复逐字流式输出
+type fakeClient struct {
+	replies []string
+}
+
+func (c *fakeClient) Chat(ctx context.Context, messages []openai.Message, stop []string) (string, error) {
+	if len(c.replies) == 0 {
+		return "", errors.New("no more replies")
+	}
+	reply := c.replies[0]
+	c.replies = c.replies[1:]
+	return reply, nil
+}
+
+func (c *fakeClient) ChatStream(ctx context.Context, messages []openai.Message, stop []string) (iter.Seq[string], error) {
+	reply, err := c.Chat(ctx, messages, stop)
+	if err != nil {
+		return nil, err
+	}
+	return func(yield func(string) bool) {
+		for _, r := range reply {
+			if ctx.Err() != nil || !yield(string(r)) {
+				return
+			}
+		}
+	}, nil
+}
+
+func TestIterContextCancel(t *testing.T) {
+	agt := New(&fakeClient{replies: []string{"思考：我需要想一想很久很久\n最终答案：42"}}, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	it, ch := agt.IterContext(ctx, nil, "问题")
+	var n int
+	for range it {
+		if n++; n == 3 {
+			cancel()
+		}
+	}
+	msgs := (<-ch).Messages
+	if len(msgs) != 3 {
+		t.Fatalf("want system, user and partial assistant message, got %d", len(msgs))
+	}
+	if got := msgs[2].Content; got != "思考：" {
+		t.Fatalf("partial assistant message = %q", got)
+	}
+}
+
+func TestStopEarlyDeliversTranscript(t *testing.T) {
+	agt := New(&fakeClient{replies: []string{"思考：我需要想一想很久很久\n最终答案：42"}}, nil)
+	it, ch := agt.Iter(nil, "问题")
+	var n int
+	for range it {
+		if n++; n == 3 {
+			break
+		}
+	}
+	res := <-ch
+	if res.Stop != StopConsumer || res.Err != nil {
+		t.Fatalf("stop = %q, err = %v", res.Stop, res.Err)
+	}
+	if len(res.Messages) != 3 || res.Messages[2].Content != "思考：" {
+		t.Fatalf("messages = %v", res.Messages)
+	}
+}
+
+func TestErrorDeliversTranscript(t *testing.T) {
+	agt := New(&fakeClient{}, nil)
+	it, ch := agt.Iter([]openai.Message{{Role: "user", Content: "之前"}}, "问题")
+	for range it {
+	}
+	res := <-ch
+	if res.Stop != StopError || res.Err == nil {
+		t.Fatalf("stop = %q, err = %v", res.Stop, res.Err)
+	}
+	if len(res.Messages) != 3 {
+		t.Fatalf("history lost: %v", res.Messages)
+	}
+}
@@ -0,0 +1,174 @@
+package agents
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheStore 是带过期时间的键值缓存，工具缓存（WithToolCache）与模型回复缓存（NewCachedClient）都保存在其中，
+// 实现需并发安全。读写失败时视为未命中，不影响运行
+type CacheStore interface {
+	Get(key string) (value string, ok bool)
+	Set(key, value string, ttl time.Duration)
+}
+
+// Cache 使工具成功的输出在 ttl 内被缓存：Agent 设置了 WithToolCache 时，
+// 同名工具以等价的动作输入再次调用会直接返回缓存的输出而不执行工具。
+// 只适用于结果只取决于输入的工具，例如搜索；当前时间、随机数等工具不应缓存。
+// Sensitive 工具的输出不会写入缓存，FileCache 等实现可能以明文保存
+func Cache(ttl time.Duration) ToolOption {
+	return func(t *tool) { t.CacheTTL = ttl }
+}
+
+// WithToolCache 设置工具输出的缓存，只有以 Cache 注册的工具会被缓存。
+// 命中缓存时，观察对应的 Event 的 Cached 为 true
+func (a *Agent) WithToolCache(c CacheStore) *Agent {
+	a.toolCache = c
+	return a
+}
+
+// toolCacheKey 由工具名与规范化的动作输入生成缓存键：JSON 输入按键排序并去掉空白，
+// 其他输入合并连续的空白，与 CallFunc 按空白分隔参数的方式一致
+func toolCacheKey(name, input string) string {
+	input = strings.TrimSpace(input)
+	var v any
+	if json.Unmarshal([]byte(input), &v) == nil {
+		b, _ := json.Marshal(v)
+		input = string(b)
+	} else {
+		input = strings.Join(strings.Fields(input), " ")
+	}
+	sum := sha256.Sum256([]byte(name + "\x00" + input))
+	return hex.EncodeToString(sum[:])
+}
+
+// LRUCache 是内存中的 CacheStore，最多保存 size 项，超出时淘汰最久未使用的一项
+type LRUCache struct {
+	mu    sync.Mutex
+	size  int
+	items map[string]*list.Element
+	order *list.List // 最近使用的在前
+	now   func() time.Time
+}
+
+type lruEntry struct {
+	key     string
+	output  string
+	expires time.Time
+}
+
+func NewLRUCache(size int) *LRUCache {
+	if size <= 0 {
+		panic("agents: cache size must be positive")
+	}
+	return &LRUCache{size: size, items: map[string]*list.Element{}, order: list.New(), now: time.Now}
+}
+
+func (c *LRUCache) Get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return "", false
+	}
+	e := el.Value.(*lruEntry)
+	if !c.now().Before(e.expires) {
+		c.order.Remove(el)
+		delete(c.items, key)
+		return "", false
+	}
+	c.order.MoveToFront(el)
+	return e.output, true
+}
+
+func (c *LRUCache) Set(key, output string, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expires := c.now().Add(ttl)
+	if el, ok := c.items[key]; ok {
+		e := el.Value.(*lruEntry)
+		e.output, e.expires = output, expires
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.order.PushFront(&lruEntry{key: key, output: output, expires: expires})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruEntry).key)
+	}
+}
+
+// Len 返回缓存的项数，包括已过期但尚未淘汰的项
+func (c *LRUCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// FileCache 是把每项保存为目录下一个文件的 CacheStore，可在进程间与重启后共享。
+// 内容以明文保存（Caching 不缓存 Sensitive 工具的输出）；过期的文件在读取时删除
+type FileCache struct {
+	dir string
+	now func() time.Time
+}
+
+type fileCacheEntry struct {
+	Output  string    `json:"output"`
+	Expires time.Time `json:"expires"`
+}
+
+// NewFileCache 返回保存在 dir 下的 FileCache，dir 不存在时创建
+func NewFileCache(dir string) (*FileCache, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &FileCache{dir: dir, now: time.Now}, nil
+}
+
+func (c *FileCache) path(key string) string {
+	// 键来自 toolCacheKey，是十六进制的摘要；其他键同样取摘要，避免出现路径分隔符
+	if _, err := hex.DecodeString(key); err != nil {
+		sum := sha256.Sum256([]byte(key))
+		key = hex.EncodeToString(sum[:])
+	}
+	return filepath.Join(c.dir, key+".json")
+}
+
+func (c *FileCache) Get(key string) (string, bool) {
+	path := c.path(key)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", false
+	}
+	var e fileCacheEntry
+	if json.Unmarshal(data, &e) != nil || !c.now().Before(e.Expires) {
+		os.Remove(path)
+		return "", false
+	}
+	return e.Output, true
+}
+
+func (c *FileCache) Set(key, output string, ttl time.Duration) {
+	data, err := json.Marshal(fileCacheEntry{Output: output, Expires: c.now().Add(ttl)})
+	if err != nil {
+		return
+	}
+	f, err := os.CreateTemp(c.dir, ".cache-*")
+	if err != nil {
+		return
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(data)
+	if cerr := f.Close(); err != nil || cerr != nil {
+		return
+	}
+	os.Rename(f.Name(), c.path(key))
+}
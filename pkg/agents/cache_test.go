@@ -0,0 +1,108 @@
+package agents
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestToolCacheKey(t *testing.T) {
+	same := [][2]string{
+		{"golang  generics", " golang generics\n"},
+		{`{"q": "go", "n": 1}`, `{"n":1,"q":"go"}`},
+	}
+	for _, c := range same {
+		if toolCacheKey("web.search", c[0]) != toolCacheKey("web.search", c[1]) {
+			t.Errorf("%q and %q have different keys", c[0], c[1])
+		}
+	}
+	if toolCacheKey("web.search", "go") == toolCacheKey("web.get", "go") {
+		t.Error("different tools share a key")
+	}
+}
+
+func TestLRUCache(t *testing.T) {
+	c := NewLRUCache(2)
+	now := time.Unix(0, 0)
+	c.now = func() time.Time { return now }
+
+	c.Set("a", "1", time.Minute)
+	c.Set("b", "2", time.Minute)
+	c.Get("a")
+	c.Set("c", "3", time.Minute) // evicts b, the least recently used
+	if _, ok := c.Get("b"); ok {
+		t.Error("b not evicted")
+	}
+	if v, ok := c.Get("a"); !ok || v != "1" {
+		t.Errorf("a = %q, %v", v, ok)
+	}
+	now = now.Add(time.Minute)
+	if _, ok := c.Get("a"); ok {
+		t.Error("a not expired")
+	}
+	if c.Len() != 1 {
+		t.Errorf("len = %d, want 1", c.Len())
+	}
+}
+
+func TestFileCache(t *testing.T) {
+	c, err := NewFileCache(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := toolCacheKey("web.search", "go")
+	c.Set(key, "结果", time.Hour)
+	if v, ok := c.Get(key); !ok || v != "结果" {
+		t.Errorf("got %q, %v", v, ok)
+	}
+	c.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	if _, ok := c.Get(key); ok {
+		t.Error("entry not expired")
+	}
+	if _, ok := c.Get("../escape"); ok {
+		t.Error("unexpected hit")
+	}
+}
+
+func TestAgentToolCache(t *testing.T) {
+	var calls int
+	reg := NewRegistry().
+		MustRegister("search", func(q string) string { calls++; return "关于 " + q }, "搜索", Cache(time.Minute))
+	client := &fakeClient{}
+	agt := NewWithRegistry(client, nil, reg).WithToolCache(NewLRUCache(10))
+
+	var hits []bool
+	for _, input := range []string{"go", " go "} {
+		client.replies = []string{"动作：search\n动作输入：" + input, "最终答案：好"}
+		events, ch := agt.Stream(context.Background(), nil, "问题")
+		for state, ev := range ReactEvents(events) {
+			if state == Observing {
+				hits = append(hits, ev.Cached)
+			}
+		}
+		msgs := (<-ch).Messages
+		if got := msgs[3].Content; got != "观察：关于 go" {
+			t.Errorf("observation = %q", got)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("tool ran %d times, want 1", calls)
+	}
+	if len(hits) == 0 || hits[0] || !hits[len(hits)-1] {
+		t.Errorf("cached flags = %v", hits)
+	}
+}
+
+func TestCachingSkipsSensitive(t *testing.T) {
+	c := NewLRUCache(10)
+	var calls int
+	call := Caching(c)(func(context.Context, ToolRequest) ToolResult {
+		calls++
+		return ToolResult{Output: "secret"}
+	})
+	req := ToolRequest{Tool: ToolInfo{Name: "env", CacheTTL: time.Minute, Sensitive: true}, Input: "KEY"}
+	call(context.Background(), req)
+	if res := call(context.Background(), req); res.Cached || calls != 2 || c.Len() != 0 {
+		t.Errorf("sensitive output cached: calls = %d, len = %d", calls, c.Len())
+	}
+}
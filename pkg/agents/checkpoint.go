@@ -0,0 +1,201 @@
+package agents
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/eastlaugh/agent/pkg/logging"
+	"github.com/eastlaugh/agent/pkg/openai"
+)
+
+// Checkpoint 是一次运行在某一步之后的状态，足以在进程重启后用 Resume 继续
+type Checkpoint struct {
+	ID             string `json:"id"`
+	ConversationID string `json:"conversation_id,omitempty"`
+	Question       string `json:"question"`
+	// Messages 是截至该步的完整历史，开头为系统提示词
+	Messages []openai.Message `json:"messages"`
+	// Step 为已完成的“动作/观察”步数
+	Step int `json:"step"`
+	// Pending 为已解析但尚未得到观察的工具调用，恢复时会重新执行
+	Pending *ToolCall `json:"pending,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// ToolCall 是模型给出的一次工具调用
+type ToolCall struct {
+	Tool  string `json:"tool"`
+	Input string `json:"input"`
+}
+
+// ErrNoCheckpoint 表示检查点不存在，运行已正常结束的检查点会被删除
+var ErrNoCheckpoint = errors.New("agents: checkpoint not found")
+
+// CheckpointStore 保存检查点，实现需并发安全
+type CheckpointStore interface {
+	Save(ctx context.Context, cp *Checkpoint) error
+	// Load 在检查点不存在时返回 ErrNoCheckpoint
+	Load(ctx context.Context, id string) (*Checkpoint, error)
+	Delete(ctx context.Context, id string) error
+}
+
+// WithCheckpoints 在每一步之后（以及执行工具之前）把运行状态保存到 store，运行正常结束
+// （最终答案或转交）后删除；被取消、出错或进程退出时保留，可用 Resume 继续。
+// 只有顶层运行保存检查点，子 Agent 与计划-执行模式的各步不保存
+func (a *Agent) WithCheckpoints(store CheckpointStore) *Agent {
+	a.checkpoints = store
+	return a
+}
+
+// WithCheckpointID 指定本次运行检查点的 ID，例如会话 ID，未指定时随机生成并作为 checkpoint_id 记录在日志中。
+// 同一个 ID 的检查点会被后来的运行覆盖
+func WithCheckpointID(id string) RunOption {
+	return func(c *runConfig) { c.checkpointID = id }
+}
+
+// Resume 从检查点 id 继续被中断的运行，已完成的步骤与工具调用不会重复执行，
+// 中断时尚未得到观察的工具调用会重新执行。历史沿用检查点中的系统提示词，
+// opts 中的 WithTools 等选项应与原运行一致，WithInstructions 不再生效。
+// 未设置 WithCheckpoints 或检查点不存在时返回错误
+func (a *Agent) Resume(ctx context.Context, id string, opts ...RunOption) (iter.Seq[Event], <-chan Transcript, error) {
+	if a.checkpoints == nil {
+		return nil, nil, errors.New("agents: resume without a checkpoint store")
+	}
+	cp, err := a.checkpoints.Load(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	var cfg runConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.conversationID == "" {
+		cfg.conversationID = cp.ConversationID
+	}
+	ctx = logging.WithAttrs(ctx, slog.String("checkpoint_id", cp.ID))
+	ctx = a.withRunAttrs(ctx, cfg)
+	a.logger.InfoContext(ctx, "resuming run", slog.Int("step", cp.Step), slog.Bool("pending_tool", cp.Pending != nil))
+	events, ch := a.run(ctx, cfg, a.runTools(cfg), *cp)
+	return events, ch, nil
+}
+
+// MemoryCheckpoints 把检查点保存在内存中，适合测试或只需要在进程内恢复的场景
+type MemoryCheckpoints struct {
+	mu  sync.Mutex
+	cps map[string]Checkpoint
+}
+
+func NewMemoryCheckpoints() *MemoryCheckpoints {
+	return &MemoryCheckpoints{cps: map[string]Checkpoint{}}
+}
+
+func (s *MemoryCheckpoints) Save(_ context.Context, cp *Checkpoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := *cp
+	c.Messages = slices.Clone(cp.Messages)
+	s.cps[cp.ID] = c
+	return nil
+}
+
+func (s *MemoryCheckpoints) Load(_ context.Context, id string) (*Checkpoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.cps[id]
+	if !ok {
+		return nil, ErrNoCheckpoint
+	}
+	c.Messages = slices.Clone(c.Messages)
+	return &c, nil
+}
+
+func (s *MemoryCheckpoints) Delete(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.cps, id)
+	return nil
+}
+
+// FileCheckpoints 把每个检查点保存为目录下的 <id>.json，写入时先写临时文件再改名，
+// 进程中途退出不会留下不完整的检查点
+type FileCheckpoints struct {
+	dir string
+}
+
+// NewFileCheckpoints 返回保存在 dir 下的 FileCheckpoints，dir 不存在时创建
+func NewFileCheckpoints(dir string) (*FileCheckpoints, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &FileCheckpoints{dir: dir}, nil
+}
+
+func (s *FileCheckpoints) path(id string) (string, error) {
+	if id == "" || id != filepath.Base(id) || id == "." || id == ".." {
+		return "", fmt.Errorf("agents: invalid checkpoint id %q", id)
+	}
+	return filepath.Join(s.dir, id+".json"), nil
+}
+
+func (s *FileCheckpoints) Save(_ context.Context, cp *Checkpoint) error {
+	path, err := s.path(cp.ID)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(s.dir, ".checkpoint-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func (s *FileCheckpoints) Load(_ context.Context, id string) (*Checkpoint, error) {
+	path, err := s.path(id)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNoCheckpoint
+	}
+	if err != nil {
+		return nil, err
+	}
+	var cp Checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("agents: corrupt checkpoint %q: %w", id, err)
+	}
+	return &cp, nil
+}
+
+func (s *FileCheckpoints) Delete(_ context.Context, id string) error {
+	path, err := s.path(id)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
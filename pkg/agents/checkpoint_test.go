@@ -0,0 +1,100 @@
+package agents
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// drain 消费 events，返回运行中的恐慌
+func drain(events func(func(Event) bool)) (r any) {
+	defer func() { r = recover() }()
+	for range events {
+	}
+	return nil
+}
+
+func TestResume(t *testing.T) {
+	var calls int
+	reg := NewRegistry().MustRegister("count", func() int { calls++; return calls }, "计数")
+	store := NewMemoryCheckpoints()
+
+	// 第一步完成后模型请求失败，运行中断
+	crashed := NewWithRegistry(&fakeClient{replies: []string{"动作：count\n动作输入：\n"}}, nil, reg).WithCheckpoints(store)
+	events, ch := crashed.Stream(context.Background(), nil, "问题", WithCheckpointID("c1"))
+	drain(events)
+	if res := <-ch; res.Stop != StopError {
+		t.Fatalf("stop = %q, want error", res.Stop)
+	}
+	cp, err := store.Load(context.Background(), "c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp.Step != 1 || cp.Pending != nil || cp.Messages[len(cp.Messages)-1].Content != "观察：1" {
+		t.Fatalf("checkpoint = %+v", cp)
+	}
+
+	agt := NewWithRegistry(&fakeClient{replies: []string{"最终答案：done"}}, nil, reg).WithCheckpoints(store)
+	events, ch, err = agt.Resume(context.Background(), "c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := drain(events); r != nil {
+		t.Fatal(r)
+	}
+	msgs := (<-ch).Messages
+	if calls != 1 {
+		t.Errorf("tool ran %d times, want 1", calls)
+	}
+	if got := msgs[len(msgs)-1].Content; got != "最终答案：done" {
+		t.Errorf("last message = %q", got)
+	}
+	if _, err := store.Load(context.Background(), "c1"); !errors.Is(err, ErrNoCheckpoint) {
+		t.Errorf("checkpoint kept after the run finished: %v", err)
+	}
+}
+
+func TestResumePendingTool(t *testing.T) {
+	var calls int
+	reg := NewRegistry().MustRegister("count", func() int { calls++; return calls }, "计数")
+	store, err := NewFileCheckpoints(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 工具执行前进程“退出”
+	crashed := NewWithRegistry(&fakeClient{replies: []string{"动作：count\n动作输入：\n"}}, nil, reg).WithCheckpoints(store)
+	events, _ := crashed.Stream(context.Background(), nil, "问题", WithCheckpointID("c2"),
+		WithApproval(func(context.Context, string, string) bool { panic("killed") }))
+	if drain(events) != "killed" {
+		t.Fatal("expected the run to be killed")
+	}
+	cp, err := store.Load(context.Background(), "c2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp.Pending == nil || cp.Pending.Tool != "count" || cp.Step != 0 {
+		t.Fatalf("checkpoint = %+v", cp)
+	}
+
+	agt := NewWithRegistry(&fakeClient{replies: []string{"最终答案：done"}}, nil, reg).WithCheckpoints(store)
+	events, ch, err := agt.Resume(context.Background(), "c2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	drain(events)
+	msgs := (<-ch).Messages
+	if calls != 1 {
+		t.Errorf("tool ran %d times, want 1", calls)
+	}
+	if got := msgs[len(msgs)-2].Content; got != "观察：1" {
+		t.Errorf("observation = %q", got)
+	}
+
+	if _, _, err := agt.Resume(context.Background(), "c2"); !errors.Is(err, ErrNoCheckpoint) {
+		t.Errorf("resume finished run: err = %v", err)
+	}
+	if _, err := store.Load(context.Background(), "../c2"); err == nil {
+		t.Error("path traversal accepted")
+	}
+}
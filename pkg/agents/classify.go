@@ -0,0 +1,100 @@
+package agents
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/eastlaugh/agent/pkg/openai"
+)
+
+// Classifier 为问题选出一个 Route 的名称
+type Classifier interface {
+	Classify(ctx context.Context, messages []openai.Message, question string, routes []Route) (string, error)
+}
+
+// ClassifierFunc 把函数适配为 Classifier
+type ClassifierFunc func(ctx context.Context, messages []openai.Message, question string, routes []Route) (string, error)
+
+func (f ClassifierFunc) Classify(ctx context.Context, messages []openai.Message, question string, routes []Route) (string, error) {
+	return f(ctx, messages, question, routes)
+}
+
+// Rule 在问题匹配 Pattern 时选择 Route
+type Rule struct {
+	Route   string
+	Pattern *regexp.Regexp
+}
+
+// Keywords 返回问题包含任一关键词（不区分大小写）时选择 route 的规则
+func Keywords(route string, words ...string) Rule {
+	quoted := make([]string, len(words))
+	for i, w := range words {
+		quoted[i] = regexp.QuoteMeta(w)
+	}
+	return Rule{Route: route, Pattern: regexp.MustCompile("(?i)" + strings.Join(quoted, "|"))}
+}
+
+// RuleClassifier 按顺序尝试 rules，选择第一个匹配的规则，都不匹配时选择 fallback
+func RuleClassifier(fallback string, rules ...Rule) Classifier {
+	return ClassifierFunc(func(_ context.Context, _ []openai.Message, question string, _ []Route) (string, error) {
+		for _, rule := range rules {
+			if rule.Pattern.MatchString(question) {
+				return rule.Route, nil
+			}
+		}
+		return fallback, nil
+	})
+}
+
+// LLMClassifier 请模型根据各 Route 的 Description 选择 Agent。
+// 历史中只附上用户的上一个问题作为上下文，以免 ReAct 过程干扰判断
+func LLMClassifier(client Client) Classifier {
+	return ClassifierFunc(func(ctx context.Context, messages []openai.Message, question string, routes []Route) (string, error) {
+		var list strings.Builder
+		for _, rt := range routes {
+			fmt.Fprintf(&list, "- %s：%s\n", rt.Name, rt.Description)
+		}
+		prompt := fmt.Sprintf(`你是一个路由器，负责把用户的问题交给最合适的 Agent。可选的 Agent：
+
+%s
+只回复一个 Agent 的名称，不要输出其他内容。`, list.String())
+
+		msgs := []openai.Message{{Role: "system", Content: prompt}}
+		if prev := lastUserMessage(messages); prev != "" {
+			msgs = append(msgs, openai.Message{Role: "user", Content: "上一个问题：" + prev})
+		}
+		msgs = append(msgs, openai.Message{Role: "user", Content: question})
+		reply, err := client.Chat(ctx, msgs, nil)
+		if err != nil {
+			return "", err
+		}
+		reply = strings.Trim(strings.TrimSpace(reply), "`'\"。.")
+		for _, rt := range routes {
+			if reply == rt.Name {
+				return rt.Name, nil
+			}
+		}
+		// 模型有时会多说几句，接受恰好提到一个名称的回复
+		var mentioned []string
+		for _, rt := range routes {
+			if strings.Contains(reply, rt.Name) {
+				mentioned = append(mentioned, rt.Name)
+			}
+		}
+		if len(mentioned) == 1 {
+			return mentioned[0], nil
+		}
+		return "", fmt.Errorf("agents: classifier replied %q, which names no single route", reply)
+	})
+}
+
+func lastUserMessage(messages []openai.Message) string {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == "user" && !strings.HasPrefix(messages[i].Content, "观察：") {
+			return messages[i].Content
+		}
+	}
+	return ""
+}
@@ -0,0 +1,109 @@
+package agents
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/eastlaugh/agent/pkg/openai"
+)
+
+// Critic 审查一次运行的轨迹与最终答案，返回空字符串表示通过，否则返回给模型的修改意见
+type Critic interface {
+	Review(ctx context.Context, messages []openai.Message, answer string) (feedback string, err error)
+}
+
+// CriticFunc 把函数适配为 Critic
+type CriticFunc func(ctx context.Context, messages []openai.Message, answer string) (string, error)
+
+func (f CriticFunc) Review(ctx context.Context, messages []openai.Message, answer string) (string, error) {
+	return f(ctx, messages, answer)
+}
+
+// DefaultCriteria 是 LLMCritic 未指定标准时使用的审查标准
+var DefaultCriteria = []string{
+	"答案与观察到的工具结果一致，没有编造或与之矛盾的内容",
+	"答案完整地回答了用户的问题",
+}
+
+// LLMCritic 请模型按 criteria 审查轨迹与最终答案，criteria 为空时使用 DefaultCriteria
+func LLMCritic(client Client, criteria ...string) Critic {
+	if len(criteria) == 0 {
+		criteria = DefaultCriteria
+	}
+	prompt := fmt.Sprintf(`你是一个严格的审查者。下面是一个 ReAct Agent 解决问题的完整过程与它的最终答案，请按以下标准审查：
+
+- %s
+
+如果最终答案满足全部标准，只回复“通过”；否则回复“不通过：”以及具体的修改意见。`, strings.Join(criteria, "\n- "))
+
+	return CriticFunc(func(ctx context.Context, messages []openai.Message, answer string) (string, error) {
+		var transcript strings.Builder
+		for _, m := range messages {
+			if m.Role == "system" {
+				continue
+			}
+			fmt.Fprintf(&transcript, "[%s]\n%s\n\n", m.Role, m.Content)
+		}
+		reply, err := client.Chat(ctx, []openai.Message{
+			{Role: "system", Content: prompt},
+			{Role: "user", Content: transcript.String()},
+		}, nil)
+		if err != nil {
+			return "", err
+		}
+		// 只看回复的开头：以“通过”开头的回复即使提到“不通过”也算通过；两者都不是时视为通过
+		reply = strings.TrimSpace(reply)
+		if strings.HasPrefix(reply, "不通过") {
+			feedback := strings.TrimLeft(strings.TrimPrefix(reply, "不通过"), "：: \n")
+			if feedback == "" {
+				feedback = "答案没有通过审查"
+			}
+			return feedback, nil
+		}
+		return "", nil
+	})
+}
+
+// WithCritic 在模型给出最终答案时请 critic 审查，未通过时把意见以“审查：”交给模型并继续 ReAct 循环，
+// 最多修订 maxRevisions 轮，之后的答案直接采纳。审查出错时记录日志并采纳答案。
+// 被拒绝的答案已经产出，消费者应以“审查：”（ReactIter 中为 Reviewing 状态）之后的答案为准
+func WithCritic(critic Critic, maxRevisions int) RunOption {
+	if maxRevisions <= 0 {
+		panic("agents: max revisions must be positive")
+	}
+	return func(c *runConfig) {
+		c.checks = append(c.checks, answerCheck{name: "critic", marker: "审查：", max: maxRevisions, check: critic.Review})
+	}
+}
+
+// answerCheck 在模型给出最终答案时调用，返回非空的反馈时拒绝该答案，最多拒绝 max 次。
+// 反馈以 marker 开头交给模型，例如“审查：”或“观察：”
+type answerCheck struct {
+	name   string
+	marker string
+	max    int
+	check  func(ctx context.Context, messages []openai.Message, answer string) (string, error)
+}
+
+// reviewAnswer 依次执行尚有余量的检查，返回第一条反馈，以检查的 marker 开头；
+// rejections 记录各检查已拒绝的次数
+func (a *Agent) reviewAnswer(ctx context.Context, checks []answerCheck, rejections []int, messages []openai.Message, answer string) string {
+	for i, c := range checks {
+		if rejections[i] >= c.max {
+			continue
+		}
+		feedback, err := c.check(ctx, messages, answer)
+		if err != nil {
+			a.logger.WarnContext(ctx, "answer check failed, accepting the answer", slog.String("check", c.name), slog.Any("error", err))
+			continue
+		}
+		if feedback = strings.TrimSpace(feedback); feedback != "" {
+			rejections[i]++
+			a.logger.InfoContext(ctx, "answer rejected", slog.String("check", c.name), slog.Int("round", rejections[i]), slog.String("feedback", feedback))
+			return c.marker + feedback
+		}
+	}
+	return ""
+}
@@ -0,0 +1,80 @@
+package agents
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/eastlaugh/agent/pkg/openai"
+)
+
+func TestCriticRevision(t *testing.T) {
+	agt := New(&fakeClient{replies: []string{"最终答案：43", "最终答案：42"}}, nil)
+	var reviewed []string
+	critic := CriticFunc(func(_ context.Context, _ []openai.Message, answer string) (string, error) {
+		reviewed = append(reviewed, answer)
+		if answer != "42" {
+			return "与观察矛盾", nil
+		}
+		return "", nil
+	})
+
+	it, ch := agt.IterContext(context.Background(), nil, "问题", WithCritic(critic, 3))
+	var reviews []string
+	for state, chunk := range ReactIter(it) {
+		if state == Reviewing {
+			reviews = append(reviews, strings.TrimSpace(chunk))
+		}
+	}
+	res := <-ch
+	msgs := res.Messages
+	if strings.Join(reviewed, ",") != "43,42" {
+		t.Errorf("reviewed = %q", reviewed)
+	}
+	if len(reviews) != 1 || reviews[0] != "与观察矛盾" {
+		t.Errorf("reviews = %q", reviews)
+	}
+	if got := msgs[3].Content; !strings.HasPrefix(got, "审查：与观察矛盾") {
+		t.Errorf("feedback message = %q", got)
+	}
+	if got := msgs[len(msgs)-1].Content; got != "最终答案：42" {
+		t.Errorf("last message = %q", got)
+	}
+	if got := res.Answer(); got != "42" {
+		t.Errorf("answer = %q, want only the accepted one", got)
+	}
+}
+
+func TestCriticBounded(t *testing.T) {
+	agt := New(&fakeClient{replies: []string{"最终答案：1", "最终答案：2", "最终答案：3"}}, nil)
+	var calls int
+	critic := CriticFunc(func(context.Context, []openai.Message, string) (string, error) {
+		calls++
+		return "不够好", nil
+	})
+	it, ch := agt.IterContext(context.Background(), nil, "问题", WithCritic(critic, 2))
+	for range it {
+	}
+	msgs := (<-ch).Messages
+	if calls != 2 {
+		t.Errorf("critic called %d times, want 2", calls)
+	}
+	if got := msgs[len(msgs)-1].Content; got != "最终答案：3" {
+		t.Errorf("last message = %q", got)
+	}
+}
+
+func TestLLMCritic(t *testing.T) {
+	for reply, want := range map[string]string{
+		"通过":          "",
+		"通过，没有不通过的标准": "",
+		"不通过：答案与观察矛盾，应为 42": "答案与观察矛盾，应为 42",
+		"不通过": "答案没有通过审查",
+	} {
+		client := &fakeClient{replies: []string{reply}}
+		got, err := LLMCritic(client, "答案必须是数字").Review(context.Background(), nil, "43")
+		if err != nil || got != want {
+			t.Errorf("reply %q: got %q, %v", reply, got, err)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package agents
+
+import "strings"
+
+// FormatPolicy 决定模型的回复既没有动作也没有最终答案（格式错误）时如何处理
+type FormatPolicy struct {
+	// MaxRetries 是单次运行中最多提醒模型改正格式的次数，与“动作/观察”步数分开计算；
+	// 用尽后再次出现格式错误时结束运行，Transcript 的 Stop 为 StopFormatViolation、Err 为 ErrFormatViolation
+	MaxRetries int
+	// DropFailed 为 true 时，模型恢复正确格式后从历史中删去格式错误的回复与对应的提醒，
+	// 使交付的 messages 与之后发给模型的历史不包含失败的尝试
+	DropFailed bool
+	// PlainAnswer 为 true 时，不含任何 ReAct 标记的回复被视为最终答案，
+	// 历史中记为“最终答案：”加回复，并在回复之后补充产出“最终答案：”与答案
+	PlainAnswer bool
+}
+
+// DefaultFormatPolicy 是未调用 WithFormatPolicy 时使用的策略
+var DefaultFormatPolicy = FormatPolicy{MaxRetries: 3}
+
+// WithFormatPolicy 设置格式错误的处理策略，默认为 DefaultFormatPolicy
+func (a *Agent) WithFormatPolicy(p FormatPolicy) *Agent {
+	if p.MaxRetries < 0 {
+		panic("agents: format retries must not be negative")
+	}
+	a.format = p
+	return a
+}
+
+// formatReminder 是格式错误后交给模型的提醒
+const formatReminder = "你没有遵循ReAct。你没有输出最终答案，也没有输出动作。请严格按照 ReAct 格式进行。上一条消息将被忽略。Continue!"
+
+// plainReply 判断回复是否不含任何 ReAct 标记
+func plainReply(text string) bool {
+	if strings.TrimSpace(text) == "" {
+		return false
+	}
+	for _, marker := range []string{"思考：", "动作：", "动作输入：", "观察：", "最终答案："} {
+		if strings.Contains(text, marker) {
+			return false
+		}
+	}
+	return true
+}
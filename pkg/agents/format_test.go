@@ -0,0 +1,73 @@
+package agents
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestFormatRetriesBounded(t *testing.T) {
+	agt := New(&fakeClient{replies: []string{"思考：嗯", "思考：嗯", "思考：嗯", "最终答案：不该到这里"}}, nil).
+		WithFormatPolicy(FormatPolicy{MaxRetries: 2})
+	it, ch := agt.IterContext(context.Background(), nil, "问题")
+	for range it {
+	}
+	res := <-ch
+	if res.Stop != StopFormatViolation || !errors.Is(res.Err, ErrFormatViolation) {
+		t.Errorf("stop = %q, err = %v", res.Stop, res.Err)
+	}
+}
+
+func TestFormatViolationKeepsFailed(t *testing.T) {
+	agt := New(&fakeClient{replies: []string{"思考：嗯", "思考：还是嗯"}}, nil).
+		WithFormatPolicy(FormatPolicy{MaxRetries: 1, DropFailed: true})
+	it, ch := agt.IterContext(context.Background(), nil, "问题")
+	for range it {
+	}
+	res := <-ch
+	if res.Stop != StopFormatViolation {
+		t.Fatalf("stop = %q", res.Stop)
+	}
+	// 系统提示词、问题、两次格式错误的回复与其间的提醒
+	if len(res.Messages) != 5 || res.Messages[4].Content != "思考：还是嗯" {
+		t.Errorf("failed attempts dropped: %v", res.Messages)
+	}
+}
+
+func TestFormatDropFailed(t *testing.T) {
+	agt := New(&fakeClient{replies: []string{"思考：嗯", "思考：还是嗯", "思考：好了\n最终答案：42"}}, nil).
+		WithFormatPolicy(FormatPolicy{MaxRetries: 3, DropFailed: true})
+	it, ch := agt.IterContext(context.Background(), nil, "问题")
+	for range it {
+	}
+	msgs := (<-ch).Messages
+	// 系统提示词、问题、最终答案
+	if len(msgs) != 3 {
+		t.Fatalf("got %d messages, want 3: %v", len(msgs), msgs)
+	}
+	for _, m := range msgs {
+		if m.Content == formatReminder || strings.Contains(m.Content, "嗯") {
+			t.Errorf("failed attempt kept: %q", m.Content)
+		}
+	}
+}
+
+func TestFormatPlainAnswer(t *testing.T) {
+	agt := New(&fakeClient{replies: []string{"你好！有什么可以帮你？"}}, nil).
+		WithFormatPolicy(FormatPolicy{PlainAnswer: true})
+	it, ch := agt.IterContext(context.Background(), nil, "你好")
+	var answer strings.Builder
+	for state, chunk := range ReactIter(it) {
+		if state == Answering {
+			answer.WriteString(chunk)
+		}
+	}
+	msgs := (<-ch).Messages
+	if got := strings.TrimSpace(answer.String()); got != "你好！有什么可以帮你？" {
+		t.Errorf("answer = %q", got)
+	}
+	if got, _ := finalAnswer(msgs[len(msgs)-1].Content); got != "你好！有什么可以帮你？" {
+		t.Errorf("last message = %q", msgs[len(msgs)-1].Content)
+	}
+}
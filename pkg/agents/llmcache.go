@@ -0,0 +1,145 @@
+package agents
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"iter"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/eastlaugh/agent/pkg/openai"
+)
+
+// DefaultMaxCachedBytes 是 CachedClient 默认缓存的单条回复的最大长度
+const DefaultMaxCachedBytes = 64 << 10
+
+// 命中缓存时 ChatStream 每个片段的最大字符数
+const cachedChunkRunes = 8
+
+// CachedClient 包装 Client，以模型、消息与停止序列的摘要为键缓存回复。
+// openai.Client 的温度固定为 0，同样的请求得到的回复基本相同，缓存可以省去重复的调用。
+// Chat 与 ChatStream 共用缓存：命中时 ChatStream 把缓存的回复切成小段流式产出。
+// 回复保存在 CacheStore 中，条目数由其实现限制（如 NewLRUCache 的 size）
+//
+//	client := agents.NewCachedClient(openaiClient, openaiClient.Model, agents.NewLRUCache(500))
+//	agt := agents.NewWithRegistry(client, nil, reg)
+type CachedClient struct {
+	client   Client
+	model    string
+	cache    CacheStore
+	ttl      time.Duration
+	maxBytes int
+}
+
+// NewCachedClient 返回缓存 client 回复的 Client，model 是 client 使用的模型，作为键的一部分
+func NewCachedClient(client Client, model string, cache CacheStore) *CachedClient {
+	return &CachedClient{client: client, model: model, cache: cache, ttl: 24 * time.Hour, maxBytes: DefaultMaxCachedBytes}
+}
+
+// WithTTL 设置回复的缓存时长，默认为 24 小时
+func (c *CachedClient) WithTTL(d time.Duration) *CachedClient {
+	if d <= 0 {
+		panic("agents: cache ttl must be positive")
+	}
+	c.ttl = d
+	return c
+}
+
+// WithMaxBytes 设置缓存的单条回复的最大字节数，更长的回复不缓存，默认为 DefaultMaxCachedBytes
+func (c *CachedClient) WithMaxBytes(n int) *CachedClient {
+	if n <= 0 {
+		panic("agents: max cached bytes must be positive")
+	}
+	c.maxBytes = n
+	return c
+}
+
+type bypassCacheKey struct{}
+
+// BypassCache 使 ctx 中的请求不读取 CachedClient 的缓存，而是请求模型并以新的回复更新缓存
+func BypassCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, bypassCacheKey{}, true)
+}
+
+func cacheBypassed(ctx context.Context) bool {
+	b, _ := ctx.Value(bypassCacheKey{}).(bool)
+	return b
+}
+
+func (c *CachedClient) key(messages []openai.Message, stop []string) string {
+	b, _ := json.Marshal(struct {
+		Model    string           `json:"model"`
+		Messages []openai.Message `json:"messages"`
+		Stop     []string         `json:"stop"`
+	}{c.model, messages, stop})
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *CachedClient) lookup(ctx context.Context, key string) (string, bool) {
+	if cacheBypassed(ctx) {
+		return "", false
+	}
+	return c.cache.Get(key)
+}
+
+func (c *CachedClient) store(key, reply string) {
+	if reply != "" && len(reply) <= c.maxBytes {
+		c.cache.Set(key, reply, c.ttl)
+	}
+}
+
+func (c *CachedClient) Chat(ctx context.Context, messages []openai.Message, stop []string) (string, error) {
+	key := c.key(messages, stop)
+	if reply, ok := c.lookup(ctx, key); ok {
+		return reply, nil
+	}
+	reply, err := c.client.Chat(ctx, messages, stop)
+	if err != nil {
+		return "", err
+	}
+	c.store(key, reply)
+	return reply, nil
+}
+
+// ChatStream 未命中时转发 client 的流，只有完整读完、未被取消且 client 没有通过 openai.StreamResult
+// 报告错误（如连接中断或超时导致回复不完整）的回复才会缓存
+func (c *CachedClient) ChatStream(ctx context.Context, messages []openai.Message, stop []string) (iter.Seq[string], error) {
+	key := c.key(messages, stop)
+	if reply, ok := c.lookup(ctx, key); ok {
+		return func(yield func(string) bool) {
+			for reply != "" && ctx.Err() == nil {
+				n := 0
+				for i := 0; i < cachedChunkRunes && n < len(reply); i++ {
+					_, size := utf8.DecodeRuneInString(reply[n:])
+					n += size
+				}
+				if !yield(reply[:n]) {
+					return
+				}
+				reply = reply[n:]
+			}
+		}, nil
+	}
+
+	var result openai.StreamResult
+	it, err := c.client.ChatStream(openai.WithStreamResult(ctx, &result), messages, stop)
+	if err != nil {
+		return nil, err
+	}
+	return func(yield func(string) bool) {
+		var reply strings.Builder
+		for chunk := range it {
+			reply.WriteString(chunk)
+			if !yield(chunk) {
+				return
+			}
+		}
+		if ctx.Err() == nil && result.Err() == nil {
+			c.store(key, reply.String())
+		}
+	}, nil
+}
@@ -0,0 +1,115 @@
+package agents
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/eastlaugh/agent/pkg/openai"
+)
+
+func TestCachedClient(t *testing.T) {
+	upstream := &fakeClient{replies: []string{"思考：这是一段比较长的回复", "另一个回复", "新回复"}}
+	client := NewCachedClient(upstream, "m", NewLRUCache(10))
+	msgs := []openai.Message{{Role: "user", Content: "你好"}}
+	ctx := context.Background()
+
+	stream := func(ctx context.Context, stop ...string) (string, int) {
+		it, err := client.ChatStream(ctx, msgs, stop)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out strings.Builder
+		var chunks int
+		for chunk := range it {
+			out.WriteString(chunk)
+			chunks++
+		}
+		return out.String(), chunks
+	}
+
+	first, _ := stream(ctx)
+	second, chunks := stream(ctx)
+	if first != "思考：这是一段比较长的回复" || second != first {
+		t.Fatalf("replies = %q, %q", first, second)
+	}
+	if chunks < 2 {
+		t.Errorf("cached reply streamed in %d chunks", chunks)
+	}
+	if reply, _ := client.Chat(ctx, msgs, nil); reply != first {
+		t.Errorf("Chat = %q, want the cached stream reply", reply)
+	}
+	if len(upstream.replies) != 2 {
+		t.Errorf("upstream called %d times, want 1", 3-len(upstream.replies))
+	}
+
+	// different stop sequences are different requests
+	if got, _ := stream(ctx, "观察："); got != "另一个回复" {
+		t.Errorf("reply with stop = %q", got)
+	}
+	// bypass refreshes the entry
+	if got, _ := stream(BypassCache(ctx)); got != "新回复" {
+		t.Errorf("bypassed reply = %q", got)
+	}
+	if got, _ := stream(ctx); got != "新回复" {
+		t.Errorf("reply after bypass = %q", got)
+	}
+}
+
+func TestCachedClientLimits(t *testing.T) {
+	upstream := &fakeClient{replies: []string{"很长的回复", "很长的回复", "部分", "部分"}}
+	client := NewCachedClient(upstream, "m", NewLRUCache(10)).WithMaxBytes(4)
+	msgs := []openai.Message{{Role: "user", Content: "你好"}}
+	client.Chat(context.Background(), msgs, nil)
+	client.Chat(context.Background(), msgs, nil)
+	if len(upstream.replies) != 2 {
+		t.Errorf("oversized reply was cached")
+	}
+
+	// a stream the consumer abandons is not cached
+	msgs = []openai.Message{{Role: "user", Content: "再见"}}
+	it, _ := client.ChatStream(context.Background(), msgs, nil)
+	for range it {
+		break
+	}
+	client.Chat(context.Background(), msgs, nil)
+	if len(upstream.replies) != 0 {
+		t.Errorf("partial stream was cached")
+	}
+}
+
+func TestCachedClientIncompleteStream(t *testing.T) {
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"半截\"}}]}\n\n")
+		// 第一次请求在 [DONE] 之前断开
+		if requests > 1 {
+			fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"回复\"},\"finish_reason\":\"stop\"}]}\n\ndata: [DONE]\n\n")
+		}
+	}))
+	defer srv.Close()
+	client := NewCachedClient(openai.NewClient(srv.URL, "", "m"), "m", NewLRUCache(10))
+	msgs := []openai.Message{{Role: "user", Content: "你好"}}
+
+	for i, want := range []string{"半截", "半截回复", "半截回复"} {
+		it, err := client.ChatStream(context.Background(), msgs, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out strings.Builder
+		for chunk := range it {
+			out.WriteString(chunk)
+		}
+		if out.String() != want {
+			t.Errorf("stream %d = %q, want %q", i, out.String(), want)
+		}
+	}
+	if requests != 2 {
+		t.Errorf("%d requests, want 2: the truncated reply was cached", requests)
+	}
+}
@@ -0,0 +1,224 @@
+package agents
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/eastlaugh/agent/pkg/logging"
+	"github.com/eastlaugh/agent/pkg/util"
+)
+
+// ToolRequest 是中间件看到的一次工具调用
+type ToolRequest struct {
+	Tool  ToolInfo
+	Input string
+}
+
+// ToolResult 是一次工具调用的结果
+type ToolResult struct {
+	// Output 是交给模型的观察，Failed 时为错误描述
+	Output string
+	// Failed 表示执行时发生恐慌、Handler 返回错误或被中间件拒绝
+	Failed bool
+	// Cached 表示输出来自缓存，工具没有执行
+	Cached bool
+	// args 是 Func 工具解析后的参数，供 Logging 格式化调用
+	args []any
+}
+
+// ToolFunc 执行一次工具调用
+type ToolFunc func(ctx context.Context, req ToolRequest) ToolResult
+
+// ToolMiddleware 包装工具的执行，可以在调用前拒绝、改写输入，或在调用后改写结果。
+// 每次调用的顺序为：Logging、Measure（设置了 WithMetrics 时）、Recovery、Caching（设置了 WithToolCache 时）、
+// Agent.Use 添加的中间件、注册时以 Middleware 指定的中间件，最后执行工具本身
+type ToolMiddleware func(next ToolFunc) ToolFunc
+
+// Use 为所有工具添加中间件，先添加的在外层
+func (a *Agent) Use(mws ...ToolMiddleware) *Agent {
+	a.middlewares = append(a.middlewares, mws...)
+	return a
+}
+
+// Middleware 为该工具添加中间件，位于 Agent.Use 添加的中间件之内
+func Middleware(mws ...ToolMiddleware) ToolOption {
+	return func(t *tool) { t.Middlewares = append(t.Middlewares, mws...) }
+}
+
+// toolChain 按 ToolMiddleware 所述的顺序组装 t 的执行链
+func (a *Agent) toolChain(t tool) ToolFunc {
+	mws := []ToolMiddleware{Logging(a.logger)}
+	if a.metrics != nil {
+		mws = append(mws, Measure(a.metrics))
+	}
+	mws = append(mws, Recovery())
+	if a.toolCache != nil {
+		mws = append(mws, Caching(a.toolCache))
+	}
+	mws = append(mws, a.middlewares...)
+	mws = append(mws, t.Middlewares...)
+
+	fn := t.call
+	for i := len(mws) - 1; i >= 0; i-- {
+		fn = mws[i](fn)
+	}
+	return fn
+}
+
+// Recovery 把工具执行时的恐慌转为失败的结果
+func Recovery() ToolMiddleware {
+	return func(next ToolFunc) ToolFunc {
+		return func(ctx context.Context, req ToolRequest) (res ToolResult) {
+			defer func() {
+				if r := recover(); r != nil {
+					res = ToolResult{Output: fmt.Sprintf("工具 %s 执行时发生恐慌: %v", req.Tool.Name, r), Failed: true}
+				}
+			}()
+			return next(ctx, req)
+		}
+	}
+}
+
+// 日志中工具输出的最大长度
+const maxLoggedOutput = 200
+
+// Logging 记录每次工具调用的参数、输出与耗时，Sensitive 工具的参数与输出记为 logging.Redacted
+func Logging(logger *slog.Logger) ToolMiddleware {
+	return func(next ToolFunc) ToolFunc {
+		return func(ctx context.Context, req ToolRequest) ToolResult {
+			start := time.Now()
+			res := next(ctx, req)
+
+			call := req.Tool.Name + " " + req.Input
+			if req.Tool.Func != nil && res.args != nil {
+				call = util.MarshalFuncCall(req.Tool.Func, res.args...)
+			}
+			logged := truncate(res.Output, maxLoggedOutput)
+			if req.Tool.Sensitive {
+				call, logged = logging.Redacted, logging.Redacted
+			}
+			level := slog.LevelInfo
+			if res.Failed {
+				level = slog.LevelError
+			}
+			attrs := []slog.Attr{
+				slog.String("tool", req.Tool.Name),
+				slog.String("call", call),
+				slog.String("output", logged),
+				slog.Duration("duration", time.Since(start)),
+			}
+			if res.Cached {
+				attrs = append(attrs, slog.Bool("cached", true))
+			}
+			logger.LogAttrs(ctx, level, "tool call", attrs...)
+			return res
+		}
+	}
+}
+
+// Measure 向 m 报告每次实际执行的工具调用，命中缓存的调用不报告
+func Measure(m Metrics) ToolMiddleware {
+	return func(next ToolFunc) ToolFunc {
+		return func(ctx context.Context, req ToolRequest) ToolResult {
+			start := time.Now()
+			res := next(ctx, req)
+			if !res.Cached {
+				m.ToolDone(req.Tool.Name, time.Since(start), res.Failed)
+			}
+			return res
+		}
+	}
+}
+
+// Caching 缓存以 Cache 注册的工具成功的输出，见 WithToolCache。
+// Sensitive 工具即使以 Cache 注册也不缓存，与 Logging 不记录其输出一致
+func Caching(c CacheStore) ToolMiddleware {
+	return func(next ToolFunc) ToolFunc {
+		return func(ctx context.Context, req ToolRequest) ToolResult {
+			if req.Tool.CacheTTL <= 0 || req.Tool.Sensitive {
+				return next(ctx, req)
+			}
+			key := toolCacheKey(req.Tool.Name, req.Input)
+			if output, ok := c.Get(key); ok {
+				return ToolResult{Output: output, Cached: true}
+			}
+			res := next(ctx, req)
+			// 失败的输出不缓存
+			if !res.Failed {
+				c.Set(key, res.Output, req.Tool.CacheTTL)
+			}
+			return res
+		}
+	}
+}
+
+// RateLimit 使每个工具在任意 per 时长内最多执行 n 次，超出时不执行，而是以失败的观察请模型稍后再试。
+// 同一个 RateLimit 的返回值在各 Agent、各次运行间共享计数
+func RateLimit(n int, per time.Duration) ToolMiddleware {
+	if n <= 0 || per <= 0 {
+		panic("agents: rate limit must be positive")
+	}
+	var mu sync.Mutex
+	calls := map[string][]time.Time{}
+	return func(next ToolFunc) ToolFunc {
+		return func(ctx context.Context, req ToolRequest) ToolResult {
+			now := time.Now()
+			mu.Lock()
+			recent := calls[req.Tool.Name]
+			for len(recent) > 0 && now.Sub(recent[0]) >= per {
+				recent = recent[1:]
+			}
+			allowed := len(recent) < n
+			if allowed {
+				recent = append(recent, now)
+			}
+			calls[req.Tool.Name] = recent
+			mu.Unlock()
+			if !allowed {
+				return ToolResult{Output: fmt.Sprintf("工具 %s 调用过于频繁（每 %v 最多 %d 次），请稍后再试或换一种方式", req.Tool.Name, per, n), Failed: true}
+			}
+			return next(ctx, req)
+		}
+	}
+}
+
+// Validate 在执行前用 check 检查动作输入，返回错误时不执行，而是把错误作为失败的观察交给模型
+func Validate(check func(input string) error) ToolMiddleware {
+	return func(next ToolFunc) ToolFunc {
+		return func(ctx context.Context, req ToolRequest) ToolResult {
+			if err := check(req.Input); err != nil {
+				return ToolResult{Output: fmt.Sprintf("工具 %s 的输入无效: %v", req.Tool.Name, err), Failed: true}
+			}
+			return next(ctx, req)
+		}
+	}
+}
+
+// ValidateSchema 要求动作输入是符合 schema（见 util.JSONSchema）的 JSON，用于 RegisterHandler 注册的工具
+func ValidateSchema(schema map[string]any) ToolMiddleware {
+	return Validate(func(input string) error {
+		var v any
+		if err := json.Unmarshal([]byte(input), &v); err != nil {
+			return fmt.Errorf("不是合法的 JSON: %w", err)
+		}
+		return util.ValidateJSON(schema, v)
+	})
+}
+
+// Redact 把输出中匹配 patterns 的部分替换为 logging.Redacted，使其不进入对话历史与日志
+func Redact(patterns ...*regexp.Regexp) ToolMiddleware {
+	return func(next ToolFunc) ToolFunc {
+		return func(ctx context.Context, req ToolRequest) ToolResult {
+			res := next(ctx, req)
+			for _, p := range patterns {
+				res.Output = p.ReplaceAllString(res.Output, logging.Redacted)
+			}
+			return res
+		}
+	}
+}
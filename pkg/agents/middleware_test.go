@@ -0,0 +1,70 @@
+package agents
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestToolMiddlewareOrder(t *testing.T) {
+	var order []string
+	trace := func(name string) ToolMiddleware {
+		return func(next ToolFunc) ToolFunc {
+			return func(ctx context.Context, req ToolRequest) ToolResult {
+				order = append(order, name)
+				return next(ctx, req)
+			}
+		}
+	}
+	reg := NewRegistry().MustRegister("echo", strings.TrimSpace, "", Middleware(trace("tool")))
+	agt := NewWithRegistry(&fakeClient{replies: []string{"动作：echo\n动作输入：hi", "最终答案：hi"}}, nil, reg).
+		Use(trace("first"), trace("second"))
+	it, ch := agt.Iter(nil, "问题")
+	for range it {
+	}
+	<-ch
+	if got := strings.Join(order, ","); got != "first,second,tool" {
+		t.Errorf("order = %s", got)
+	}
+}
+
+func TestToolMiddlewares(t *testing.T) {
+	var calls int
+	info := ToolInfo{Name: "lookup"}
+	base := func(_ context.Context, req ToolRequest) ToolResult {
+		calls++
+		return ToolResult{Output: "token=abc123 for " + req.Input}
+	}
+	run := func(mw ToolMiddleware, input string) ToolResult {
+		return mw(base)(context.Background(), ToolRequest{Tool: info, Input: input})
+	}
+
+	limit := RateLimit(2, time.Hour)
+	for i, want := range []bool{false, false, true} {
+		if res := run(limit, "x"); res.Failed != want {
+			t.Errorf("call %d: failed = %v, want %v", i, res.Failed, want)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("rate limited tool ran %d times, want 2", calls)
+	}
+
+	schema := map[string]any{"type": "object", "properties": map[string]any{"id": map[string]any{"type": "integer"}}, "required": []any{"id"}}
+	if res := run(ValidateSchema(schema), `{"id": "x"}`); !res.Failed || !strings.Contains(res.Output, "expected integer") {
+		t.Errorf("invalid input: %+v", res)
+	}
+	if res := run(ValidateSchema(schema), `{"id": 1}`); res.Failed {
+		t.Errorf("valid input rejected: %+v", res)
+	}
+
+	if res := run(Redact(regexp.MustCompile(`token=\w+`)), "u1"); res.Output != "[REDACTED] for u1" {
+		t.Errorf("redacted output = %q", res.Output)
+	}
+
+	panicking := func(context.Context, ToolRequest) ToolResult { panic("boom") }
+	if res := Recovery()(panicking)(context.Background(), ToolRequest{Tool: info}); !res.Failed || !strings.Contains(res.Output, "boom") {
+		t.Errorf("recovered result = %+v", res)
+	}
+}
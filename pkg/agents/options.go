@@ -0,0 +1,63 @@
+package agents
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/eastlaugh/agent/pkg/trace"
+)
+
+// Metrics 接收 Agent 运行过程中的度量数据，实现需并发安全
+type Metrics interface {
+	// RunDone 在一次运行结束时调用（包括被取消），steps 为已完成的“动作/观察”步数
+	RunDone(steps int)
+	// ToolDone 在每次工具调用结束时调用（命中缓存的除外），failed 表示调用失败，包括恐慌、Handler 返回错误与被中间件拒绝
+	ToolDone(tool string, latency time.Duration, failed bool)
+	// FormatViolation 在模型回复既没有动作也没有最终答案时调用
+	FormatViolation()
+}
+
+// WithMetrics 设置度量钩子，应在开始运行前调用
+func (a *Agent) WithMetrics(m Metrics) *Agent {
+	a.metrics = m
+	return a
+}
+
+// WithName 设置 Agent 的名称，出现在 Stream 事件的 Agent 字段与日志的 agent 属性中，便于区分子 Agent
+func (a *Agent) WithName(name string) *Agent {
+	a.name = name
+	return a
+}
+
+// WithMaxSteps 设置单次运行最多执行的“动作/观察”步数，默认为 10
+func (a *Agent) WithMaxSteps(n int) *Agent {
+	if n <= 0 {
+		panic("agents: max steps must be positive")
+	}
+	a.maxSteps = n
+	return a
+}
+
+// WithTracer 设置 tracer，每次运行会产生 agent.run → agent.step → llm.* / agent.tool 的 span 树。
+// 未设置时，若调用方传入的 ctx 中已有 span，仍会作为其子 span 记录
+func (a *Agent) WithTracer(t *trace.Tracer) *Agent {
+	a.tracer = t
+	return a
+}
+
+// WithLogger 设置日志输出，默认为 slog.Default()。配合 logging.New 可以让日志带上
+// run_id / conversation_id 并隐去密钥
+func (a *Agent) WithLogger(l *slog.Logger) *Agent {
+	a.logger = l
+	return a
+}
+
+// WithSensitiveTools 使这些工具的参数与输出不出现在日志中，例如读取环境变量的工具
+func (a *Agent) WithSensitiveTools(names ...string) *Agent {
+	for _, name := range names {
+		if !a.tools.setSensitive(name) {
+			panic("agents: unknown tool " + name)
+		}
+	}
+	return a
+}
@@ -0,0 +1,187 @@
+package agents
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/eastlaugh/agent/pkg/openai"
+	"github.com/eastlaugh/agent/pkg/trace"
+)
+
+// WithPlanning 以“计划-执行”模式运行：先请模型列出编号的计划，再把每一步交给一次 ReAct 运行，
+// 使用同样的工具执行；每步之后根据结果修订剩余的计划，或给出最终答案。
+// 计划与修订以“计划：”开头，在 ReactIter 中为 Planning 状态；每一步的 ReAct 过程照常产出，
+// 其中“最终答案：”是这一步的结果，整个问题的最终答案在最后。
+// 执行的步数受 WithMaxSteps 限制，每一步内部的动作另受同样的限制
+func WithPlanning() RunOption {
+	return func(c *runConfig) { c.planning = true }
+}
+
+var planStepRegex = regexp.MustCompile(`(?m)^\s*\d+\s*[.、．)）]\s*(.+?)\s*$`)
+
+// planSteps 从“计划：”之后的编号列表中取出各步骤
+func planSteps(plan string) []string {
+	if i := strings.Index(plan, "计划："); i >= 0 {
+		plan = plan[i:]
+	}
+	var steps []string
+	for _, m := range planStepRegex.FindAllStringSubmatch(plan, -1) {
+		steps = append(steps, m[1])
+	}
+	return steps
+}
+
+func plannerPrompt(tools *Registry) string {
+	descriptions, _ := describeTools(tools)
+	return fmt.Sprintf(`你是一个善于规划的助手。为了回答用户的问题，请先制定分步骤的计划，每一步都应该能用下列工具之一或直接推理完成：
+
+%s
+
+以“计划：”开头，之后每行一个步骤，形如“1. ……”。步骤要少而具体；只制定计划，不要执行，也不要给出答案。`, descriptions)
+}
+
+const replannerPrompt = `你负责根据执行结果修订计划。如果已经可以回答用户的问题，输出“最终答案：”以及完整的答案；
+否则以“计划：”开头，列出还需要执行的步骤，每行一个，形如“1. ……”，不要重复已完成的步骤。`
+
+func (a *Agent) planAndExecute(ctx context.Context, messages []openai.Message, question string, cfg runConfig, opts []RunOption) (iter.Seq[Event], <-chan Transcript) {
+	depth := runDepth(ctx)
+	ctx = a.withRunAttrs(ctx, cfg)
+	maxSteps := a.maxSteps
+	if cfg.maxSteps > 0 {
+		maxSteps = cfg.maxSteps
+	}
+	tools := a.runTools(cfg)
+	messages = a.runMessages(ctx, tools, cfg, messages, question)
+
+	var ch = make(chan Transcript, 1)
+	var consumed bool
+	return func(yield func(Event) bool) {
+		if consumed {
+			panic("agents: consumed iterator")
+		}
+		consumed = true
+
+		ctx, span := a.tracer.Start(ctx, "agent.plan_and_execute", trace.String("agent.question", question))
+		var stop StopReason = StopError
+		var runErr error
+		defer func() {
+			r := recover()
+			if r != nil {
+				runErr = fmt.Errorf("agents: panic: %v", r)
+			}
+			switch stop {
+			case StopFinalAnswer, StopConsumer:
+			default:
+				span.SetStatus(trace.StatusError, string(stop))
+			}
+			span.End()
+			ch <- Transcript{Messages: messages, Stop: stop, Err: runErr}
+			close(ch)
+			if r != nil {
+				panic(r)
+			}
+		}()
+		text := func(s string) bool { return yield(Event{Depth: depth, Agent: a.name, Text: s}) }
+
+		// 计划与修订都作为 assistant 消息留在历史中，后续步骤可以看到
+		planner := append([]openai.Message{{Role: "system", Content: plannerPrompt(tools)}}, messages[1:]...)
+		plan, stop, runErr := a.plan(ctx, text, planner, false)
+		if plan != "" {
+			messages = append(messages, openai.Message{Role: "assistant", Content: plan})
+		}
+		if stop != "" {
+			return
+		}
+
+		steps := planSteps(plan)
+		var done []string
+		for executed := 0; ; executed++ {
+			// 没有可执行的计划时直接以 ReAct 回答整个问题，其最终答案即为本次的最终答案
+			finishing := len(steps) == 0
+			step := question
+			if !finishing {
+				step = steps[0]
+			}
+			if executed >= maxSteps {
+				stop, runErr = StopMaxSteps, ErrMaxSteps
+				return
+			}
+
+			stepQuestion := fmt.Sprintf("执行计划的第 %d 步：%s\n只完成这一步，完成后以“最终答案：”给出这一步的结果。", executed+1, step)
+			if finishing {
+				stepQuestion = "请直接回答最初的问题：" + question
+			}
+			stepOpts := append(slices.Clone(opts), func(c *runConfig) {
+				c.planning = false
+				c.planStep = executed + 1
+			})
+			events, stepCh := a.Stream(ctx, messages, stepQuestion, stepOpts...)
+			for ev := range events {
+				if !yield(ev) {
+					break
+				}
+			}
+			t := <-stepCh
+			messages = t.Messages
+			// 某一步没有给出最终答案时，以该步的结束原因结束整个运行
+			if stop, runErr = t.Stop, t.Err; stop != StopFinalAnswer || finishing {
+				return
+			}
+			result, _ := finalAnswer(messages[len(messages)-1].Content)
+			done = append(done, fmt.Sprintf("%d. %s\n结果：%s", executed+1, step, result))
+
+			replanner := []openai.Message{
+				{Role: "system", Content: replannerPrompt},
+				{Role: "user", Content: fmt.Sprintf("问题：%s\n\n当前计划：\n%s\n\n已完成的步骤：\n%s", question, plan, strings.Join(done, "\n"))},
+			}
+			reply, replanStop, err := a.plan(ctx, text, replanner, true)
+			if reply != "" {
+				messages = append(messages, openai.Message{Role: "assistant", Content: reply})
+			}
+			if replanStop != "" {
+				stop, runErr = replanStop, err
+				return
+			}
+			if _, ok := finalAnswer(reply); ok {
+				stop = StopFinalAnswer
+				return
+			}
+			plan, steps = reply, planSteps(reply)
+		}
+	}, ch
+}
+
+// plan 流式请求计划或修订，返回已生成的回复；请求失败、被取消或消费者停止时 stop 说明原因
+func (a *Agent) plan(ctx context.Context, text func(string) bool, messages []openai.Message, replan bool) (reply string, stop StopReason, err error) {
+	ctx, span := trace.Start(ctx, "agent.plan", trace.Bool("agent.replan", replan))
+	defer span.End()
+	it, err := a.client.ChatStream(ctx, messages, nil)
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", StopCancelled, nil
+		}
+		span.RecordError(err)
+		a.logger.ErrorContext(ctx, "llm request failed", slog.Any("error", err))
+		return "", StopError, err
+	}
+	var response strings.Builder
+	for chunk := range it {
+		response.WriteString(chunk)
+		if !text(chunk) {
+			return response.String(), StopConsumer, nil
+		}
+	}
+	if ctx.Err() != nil {
+		return response.String(), StopCancelled, nil
+	}
+	// 计划之后换行，使后续步骤的“思考：”等标记从新的一行开始
+	if response.Len() > 0 && !text("\n") {
+		return response.String(), StopConsumer, nil
+	}
+	return response.String(), "", nil
+}
@@ -0,0 +1,75 @@
+package agents
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestPlanSteps(t *testing.T) {
+	got := planSteps("思考：先想想\n计划：\n1. 查询天气\n2、 比较温度 \n 3) 给出建议\n以上")
+	if want := []string{"查询天气", "比较温度", "给出建议"}; !slices.Equal(got, want) {
+		t.Errorf("planSteps = %q, want %q", got, want)
+	}
+}
+
+func TestPlanAndExecute(t *testing.T) {
+	client := &recordingClient{fakeClient: fakeClient{replies: []string{
+		"计划：\n1. 查询用户 1\n2. 计算明年的年龄", // planner
+		"思考：查一下\n动作：user\n动作输入：1",    // step 1
+		"最终答案：Alice 28 岁",            // step 1
+		"计划：\n1. 计算 28+1",            // replanner
+		"最终答案：29",                    // step 2
+		"最终答案：Alice 明年 29 岁",         // replanner
+	}}}
+	reg := NewRegistry().MustRegister("user", func(id int) string { return "Alice (age: 28)" }, "查询用户")
+	agt := NewWithRegistry(client, nil, reg)
+
+	it, ch := agt.IterContext(context.Background(), nil, "Alice 明年几岁", WithPlanning())
+	var plans []string
+	prev := Thinking
+	for state, chunk := range ReactIter(it) {
+		switch {
+		case state == Planning && prev != Planning:
+			plans = append(plans, chunk)
+		case state == Planning:
+			plans[len(plans)-1] += chunk
+		}
+		prev = state
+	}
+	msgs := (<-ch).Messages
+	for i := range plans {
+		plans[i] = strings.TrimSpace(plans[i])
+	}
+	if len(plans) != 2 || plans[0] != "1. 查询用户 1\n2. 计算明年的年龄" || plans[1] != "1. 计算 28+1" {
+		t.Errorf("plans = %q", plans)
+	}
+	if got := msgs[len(msgs)-1].Content; got != "最终答案：Alice 明年 29 岁" {
+		t.Errorf("last message = %q", got)
+	}
+
+	// the planner sees the tools, the replanner the step results
+	if !strings.Contains(client.seen[0][0].Content, "user(int)") {
+		t.Errorf("planner prompt lacks the tools:\n%s", client.seen[0][0].Content)
+	}
+	if got := client.seen[3][1].Content; !strings.Contains(got, "已完成的步骤：\n1. 查询用户 1\n结果：Alice 28 岁") {
+		t.Errorf("replanner input = %q", got)
+	}
+	// each step runs as ReAct over the shared history, which includes the plan
+	step2 := client.seen[4]
+	if !strings.Contains(step2[0].Content, "ReAct") || !strings.Contains(step2[len(step2)-1].Content, "执行计划的第 2 步：计算 28+1") {
+		t.Errorf("step 2 request = %+v", step2)
+	}
+}
+
+func TestPlanWithoutSteps(t *testing.T) {
+	agt := New(&fakeClient{replies: []string{"这个问题不需要计划", "最终答案：42"}}, nil)
+	it, ch := agt.IterContext(context.Background(), nil, "问题", WithPlanning())
+	for range it {
+	}
+	msgs := (<-ch).Messages
+	if got := msgs[len(msgs)-1].Content; got != "最终答案：42" {
+		t.Errorf("last message = %q", got)
+	}
+}
@@ -18,6 +18,10 @@ const (
 	Acting
 	Observing
 	Answering
+	// Planning 为 WithPlanning 产出的计划与修订
+	Planning
+	// Reviewing 为 WithCritic 对最终答案的修改意见，之前的答案作废
+	Reviewing
 )
 
 func (r ReAct) String() string {
@@ -30,6 +34,10 @@ func (r ReAct) String() string {
 		return "observing"
 	case Answering:
 		return "answering"
+	case Planning:
+		return "planning"
+	case Reviewing:
+		return "reviewing"
 	default:
 		return "unknown"
 	}
@@ -38,75 +46,139 @@ func (r ReAct) String() string {
 // 用于把纯文本迭代器转换为 React 风格的迭代器
 func ReactIter(it iter.Seq[string]) iter.Seq2[ReAct, string] {
 	return func(yield func(ReAct, string) bool) {
-		var currentState ReAct = Thinking
-		var buffer string
-
-		markers := []struct {
-			word  string
-			state ReAct
-		}{
-			{"动作输入：", Acting},
-			{"最终答案：", Answering},
-			{"思考：", Thinking},
-			{"动作：", Acting},
-			{"观察：", Observing},
+		p := reactParser{state: Thinking}
+		for chunk := range it {
+			if !p.feed(chunk, yield) {
+				return
+			}
 		}
+		p.flush(yield)
+	}
+}
 
-		for chunk := range it {
-			buffer += chunk
-
-			for {
-				earliestIdx := -1
-				mLen := 0
-				var nextState ReAct
-
-				for _, m := range markers {
-					if idx := strings.Index(buffer, m.word); idx != -1 {
-						if earliestIdx == -1 || idx < earliestIdx {
-							earliestIdx = idx
-							mLen = len(m.word)
-							nextState = m.state
-						}
+// ReactEvents 与 ReactIter 相同，但按 Depth 分别解析 Stream 中各层 Agent 的输出，
+// 产出的 Event 的 Text 为去掉标记后的片段
+func ReactEvents(it iter.Seq[Event]) iter.Seq2[ReAct, Event] {
+	return func(yield func(ReAct, Event) bool) {
+		parsers := map[int]*reactParser{}
+		var current Event
+		emit := func(state ReAct, text string) bool {
+			ev := current
+			ev.Text = text
+			return yield(state, ev)
+		}
+		for ev := range it {
+			if ev.Depth != current.Depth {
+				// 深度切换时上一层的输出必然告一段落（父 Agent 在等待工具，或子 Agent 已结束）
+				if p := parsers[current.Depth]; p != nil && !p.flush(emit) {
+					return
+				}
+				// 回到外层时子 Agent 已结束，下次调用从头解析
+				for d := range parsers {
+					if d > ev.Depth {
+						delete(parsers, d)
 					}
 				}
+			}
+			current = ev
+			p := parsers[ev.Depth]
+			if p == nil {
+				p = &reactParser{state: Thinking}
+				parsers[ev.Depth] = p
+			}
+			if !p.feed(ev.Text, emit) {
+				return
+			}
+			// 缓存的观察是完整的一段，立即吐出，使其片段都带有 Cached
+			if ev.Cached && !p.flush(emit) {
+				return
+			}
+		}
+		if p := parsers[current.Depth]; p != nil {
+			p.flush(emit)
+		}
+	}
+}
 
-				if earliestIdx == -1 {
-					// 【关键修正】确保切在 UTF-8 字符边界上！
-					if len(buffer) > 30 {
-						// 留出足够的空间（20字节），确保不会切断任何标记词或中文字符
-						// 我们只吐出前面确定安全的部分
-						safeCut := len(buffer) - 20
-
-						// 寻找最近的一个合法 UTF-8 字符边界
-						for !utf8.ValidString(buffer[:safeCut]) && safeCut > 0 {
-							safeCut--
-						}
-
-						if safeCut > 0 {
-							if !yield(currentState, buffer[:safeCut]) {
-								return
-							}
-							buffer = buffer[safeCut:]
-						}
-					}
-					break
+var reactMarkers = []struct {
+	word  string
+	state ReAct
+}{
+	{"动作输入：", Acting},
+	{"最终答案：", Answering},
+	{"思考：", Thinking},
+	{"动作：", Acting},
+	{"观察：", Observing},
+	{"计划：", Planning},
+	{"审查：", Reviewing},
+}
+
+// reactParser 增量地把文本切分为 ReAct 状态片段
+type reactParser struct {
+	state  ReAct
+	buffer string
+}
+
+// feed 追加 chunk，吐出已能确定状态的部分；yield 返回 false 时返回 false
+func (p *reactParser) feed(chunk string, yield func(ReAct, string) bool) bool {
+	p.buffer += chunk
+
+	for {
+		earliestIdx := -1
+		mLen := 0
+		var nextState ReAct
+
+		for _, m := range reactMarkers {
+			if idx := strings.Index(p.buffer, m.word); idx != -1 {
+				if earliestIdx == -1 || idx < earliestIdx {
+					earliestIdx = idx
+					mLen = len(m.word)
+					nextState = m.state
 				}
+			}
+		}
 
-				// 1. 标识符之前的内容吐出去
-				if earliestIdx > 0 {
-					if !yield(currentState, buffer[:earliestIdx]) {
-						return
-					}
+		if earliestIdx == -1 {
+			// 【关键修正】确保切在 UTF-8 字符边界上！
+			if len(p.buffer) > 30 {
+				// 留出足够的空间（20字节），确保不会切断任何标记词或中文字符
+				// 我们只吐出前面确定安全的部分
+				safeCut := len(p.buffer) - 20
+
+				// 寻找最近的一个合法 UTF-8 字符边界
+				for !utf8.ValidString(p.buffer[:safeCut]) && safeCut > 0 {
+					safeCut--
 				}
 
-				// 2. 剥离标识符，切换状态
-				currentState = nextState
-				buffer = buffer[earliestIdx+mLen:]
+				if safeCut > 0 {
+					if !yield(p.state, p.buffer[:safeCut]) {
+						return false
+					}
+					p.buffer = p.buffer[safeCut:]
+				}
 			}
+			return true
 		}
 
-		if len(buffer) > 0 {
-			yield(currentState, buffer)
+		// 1. 标识符之前的内容吐出去
+		if earliestIdx > 0 {
+			if !yield(p.state, p.buffer[:earliestIdx]) {
+				return false
+			}
 		}
+
+		// 2. 剥离标识符，切换状态
+		p.state = nextState
+		p.buffer = p.buffer[earliestIdx+mLen:]
+	}
+}
+
+// flush 吐出缓冲中剩余的内容，状态保持不变
+func (p *reactParser) flush(yield func(ReAct, string) bool) bool {
+	if p.buffer == "" {
+		return true
 	}
+	buffer := p.buffer
+	p.buffer = ""
+	return yield(p.state, buffer)
 }
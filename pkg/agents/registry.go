@@ -0,0 +1,309 @@
+package agents
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// toolNamePattern 是合法的工具名：以点分隔的若干段，每段以字母开头，只含字母、数字与下划线，
+// 例如 web.get、math.add、lookup_user
+var toolNamePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*(\.[A-Za-z][A-Za-z0-9_]*)*$`)
+
+// 工具名（含命名空间）的最大长度
+const maxToolNameLen = 64
+
+// ToolOption 是 Register 的可选项
+type ToolOption func(*tool)
+
+// Alias 为工具增加别名，模型使用别名时同样能调用到该工具
+func Alias(names ...string) ToolOption {
+	return func(t *tool) { t.Aliases = append(t.Aliases, names...) }
+}
+
+// Params 设置系统提示词中工具名之后的参数说明，主要用于 RegisterHandler 注册的工具，
+// 例如 `({"query": string}) 动作输入为 JSON 对象`
+func Params(s string) ToolOption {
+	return func(t *tool) { t.Params = s }
+}
+
+// Sensitive 使工具的参数与输出不出现在日志中
+func Sensitive() ToolOption {
+	return func(t *tool) { t.Sensitive = true }
+}
+
+// Registry 按名称保存工具，并发安全。迭代按名称排序，因此生成的系统提示词在每次运行间保持一致
+type Registry struct {
+	mu      sync.RWMutex
+	tools   map[string]tool
+	aliases map[string]string // 别名 -> 工具名
+}
+
+// NewRegistry 创建一个空的 Registry
+func NewRegistry() *Registry {
+	return &Registry{tools: map[string]tool{}, aliases: map[string]string{}}
+}
+
+// ValidToolName 报告 name 是否是合法的工具名或别名
+func ValidToolName(name string) bool {
+	return len(name) <= maxToolNameLen && toolNamePattern.MatchString(name)
+}
+
+// Register 以 name 注册工具。name 与别名须满足 ValidToolName，且不能与已有的工具名或别名重复。
+// fn 为 Handler 类型（如 AsTool 的返回值）时等同于 RegisterHandler
+func (r *Registry) Register(name string, fn any, desc string, opts ...ToolOption) error {
+	if h, ok := fn.(Handler); ok {
+		return r.RegisterHandler(name, h, desc, opts...)
+	}
+	return r.register(tool{Name: name, Description: desc, Func: fn}, opts)
+}
+
+// RegisterHandler 以 name 注册一个直接处理原始动作输入的工具，通常配合 Params 说明输入格式
+func (r *Registry) RegisterHandler(name string, h Handler, desc string, opts ...ToolOption) error {
+	if h == nil {
+		return fmt.Errorf("agents: tool %q has a nil handler", name)
+	}
+	return r.register(tool{Name: name, Description: desc, Handler: h}, opts)
+}
+
+func (r *Registry) register(t tool, opts []ToolOption) error {
+	for _, opt := range opts {
+		opt(&t)
+	}
+	for _, n := range append([]string{t.Name}, t.Aliases...) {
+		if !ValidToolName(n) {
+			return fmt.Errorf("agents: invalid tool name %q", n)
+		}
+	}
+	return r.add(t)
+}
+
+// MustRegister 与 Register 相同，但出错时 panic，便于链式注册
+func (r *Registry) MustRegister(name string, fn any, desc string, opts ...ToolOption) *Registry {
+	if err := r.Register(name, fn, desc, opts...); err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// Namespace 返回一个视图，经由它注册的工具名都带有 prefix. 前缀，例如 web.get
+func (r *Registry) Namespace(prefix string) *Namespace {
+	return &Namespace{r: r, prefix: prefix}
+}
+
+// add 不校验名称，供 New 按函数名注册的旧方式使用
+func (r *Registry) add(t tool) error {
+	if t.Handler == nil && (t.Func == nil || reflect.TypeOf(t.Func).Kind() != reflect.Func) {
+		return fmt.Errorf("agents: tool %q is not a func", t.Name)
+	}
+	names := append([]string{t.Name}, t.Aliases...)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, n := range names {
+		_, isTool := r.tools[n]
+		_, isAlias := r.aliases[n]
+		if isTool || isAlias || slices.Index(names, n) != i {
+			return fmt.Errorf("agents: redundant tool definition %q", n)
+		}
+	}
+	r.tools[t.Name] = t
+	for _, a := range t.Aliases {
+		r.aliases[a] = t.Name
+	}
+	return nil
+}
+
+// Lookup 按工具名或别名查找工具，返回其工具名
+func (r *Registry) Lookup(name string) (string, bool) {
+	t, ok := r.lookup(name)
+	return t.Name, ok
+}
+
+func (r *Registry) lookup(name string) (tool, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if canonical, ok := r.aliases[name]; ok {
+		name = canonical
+	}
+	t, ok := r.tools[name]
+	return t, ok
+}
+
+// Remove 删除工具及其别名，name 可以是工具名或别名。进行中的运行使用开始时的快照，不受影响
+func (r *Registry) Remove(name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if canonical, ok := r.aliases[name]; ok {
+		name = canonical
+	}
+	t, ok := r.tools[name]
+	if !ok {
+		return false
+	}
+	delete(r.tools, name)
+	for _, a := range t.Aliases {
+		delete(r.aliases, a)
+	}
+	return true
+}
+
+// match 返回 name 所指的工具：name 可以是工具名、别名或命名空间（如 web 匹配 web.get 与 web.search）。
+// 调用方需持有读锁
+func (r *Registry) match(name string) []tool {
+	if canonical, ok := r.aliases[name]; ok {
+		name = canonical
+	}
+	if t, ok := r.tools[name]; ok {
+		return []tool{t}
+	}
+	var matched []tool
+	for n, t := range r.tools {
+		if strings.HasPrefix(n, name+".") {
+			matched = append(matched, t)
+		}
+	}
+	return matched
+}
+
+// Subset 返回只包含 names 所指工具的新 Registry，names 可以是工具名、别名或命名空间，
+// 工具保留各自的别名。某个 name 没有匹配任何工具时返回错误
+func (r *Registry) Subset(names ...string) (*Registry, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	sub := NewRegistry()
+	for _, name := range names {
+		matched := r.match(name)
+		if len(matched) == 0 {
+			return nil, fmt.Errorf("agents: unknown tool %q", name)
+		}
+		for _, t := range matched {
+			if _, ok := sub.tools[t.Name]; ok {
+				continue
+			}
+			if err := sub.add(t); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return sub, nil
+}
+
+// Without 返回去掉 names 所指工具后的新 Registry，names 的含义同 Subset，不存在的名称被忽略
+func (r *Registry) Without(names ...string) *Registry {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	excluded := map[string]bool{}
+	for _, name := range names {
+		for _, t := range r.match(name) {
+			excluded[t.Name] = true
+		}
+	}
+	sub := NewRegistry()
+	for name, t := range r.tools {
+		if !excluded[name] {
+			sub.add(t)
+		}
+	}
+	return sub
+}
+
+// Names 返回排序后的工具名，不含别名
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.tools))
+	for name := range r.tools {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// ToolInfo 描述一个已注册的工具，Func 与 Handler 恰有一个不为 nil
+type ToolInfo struct {
+	Name        string
+	Description string
+	Func        any
+	Handler     Handler
+	Aliases     []string
+	Sensitive   bool
+	// 大于 0 时输出会被缓存，见 Cache
+	CacheTTL time.Duration
+}
+
+// Tools 返回按名称排序的工具快照，例如用于把工具再通过 MCP 暴露出去
+func (r *Registry) Tools() []ToolInfo {
+	var infos []ToolInfo
+	for _, t := range r.list() {
+		infos = append(infos, t.info())
+	}
+	return infos
+}
+
+// Tool 按工具名或别名返回工具
+func (r *Registry) Tool(name string) (ToolInfo, bool) {
+	t, ok := r.lookup(name)
+	if !ok {
+		return ToolInfo{}, false
+	}
+	return t.info(), true
+}
+
+func (t tool) info() ToolInfo {
+	return ToolInfo{
+		Name:        t.Name,
+		Description: t.Description,
+		Func:        t.Func,
+		Handler:     t.Handler,
+		Aliases:     slices.Clone(t.Aliases),
+		Sensitive:   t.Sensitive,
+		CacheTTL:    t.CacheTTL,
+	}
+}
+
+// list 返回按名称排序的工具快照
+func (r *Registry) list() []tool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	tools := make([]tool, 0, len(r.tools))
+	for _, t := range r.tools {
+		tools = append(tools, t)
+	}
+	slices.SortFunc(tools, func(a, b tool) int { return strings.Compare(a.Name, b.Name) })
+	return tools
+}
+
+func (r *Registry) setSensitive(name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if canonical, ok := r.aliases[name]; ok {
+		name = canonical
+	}
+	t, ok := r.tools[name]
+	if ok {
+		t.Sensitive = true
+		r.tools[name] = t
+	}
+	return ok
+}
+
+// Namespace 是 Registry 中带统一前缀的一组工具
+type Namespace struct {
+	r      *Registry
+	prefix string
+}
+
+// Register 注册名为 prefix.name 的工具；别名不加前缀
+func (n *Namespace) Register(name string, fn any, desc string, opts ...ToolOption) error {
+	return n.r.Register(n.prefix+"."+name, fn, desc, opts...)
+}
+
+// MustRegister 与 Register 相同，但出错时 panic
+func (n *Namespace) MustRegister(name string, fn any, desc string, opts ...ToolOption) *Namespace {
+	n.r.MustRegister(n.prefix+"."+name, fn, desc, opts...)
+	return n
+}
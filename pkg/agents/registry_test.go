@@ -0,0 +1,161 @@
+package agents
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/eastlaugh/agent/pkg/openai"
+)
+
+func TestRegistryNames(t *testing.T) {
+	reg := NewRegistry()
+	for _, name := range []string{"web.get", "lookup_user", "Math.Add2"} {
+		if err := reg.Register(name, strings.ToUpper, ""); err != nil {
+			t.Errorf("Register(%q): %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "web.", ".get", "web..get", "2d", "web/get", "main.f-fm", strings.Repeat("a", 65)} {
+		if err := reg.Register(name, strings.ToUpper, ""); err == nil {
+			t.Errorf("Register(%q) succeeded", name)
+		}
+	}
+	if err := reg.Register("web.get", strings.ToLower, ""); err == nil {
+		t.Error("duplicate name accepted")
+	}
+	if err := reg.Register("web.post", "not a func", ""); err == nil {
+		t.Error("non-func tool accepted")
+	}
+}
+
+func TestRegistryAliasesAndNamespaces(t *testing.T) {
+	reg := NewRegistry()
+	reg.Namespace("web").MustRegister("get", strings.ToUpper, "", Alias("http_get", "fetch"))
+
+	for _, name := range []string{"web.get", "http_get", "fetch"} {
+		if got, ok := reg.Lookup(name); !ok || got != "web.get" {
+			t.Errorf("Lookup(%q) = %q, %v", name, got, ok)
+		}
+	}
+	if err := reg.Register("fetch", strings.ToLower, ""); err == nil {
+		t.Error("name clashing with an alias accepted")
+	}
+	if err := reg.Register("other", strings.ToLower, "", Alias("web.get")); err == nil {
+		t.Error("alias clashing with a name accepted")
+	}
+	if err := reg.Register("dup", strings.ToLower, "", Alias("x", "x")); err == nil {
+		t.Error("repeated alias accepted")
+	}
+	if got := reg.Names(); !slices.Equal(got, []string{"web.get"}) {
+		t.Errorf("Names() = %v", got)
+	}
+
+	sub, err := reg.Subset("fetch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := sub.Lookup("http_get"); !ok || got != "web.get" {
+		t.Errorf("subset lost alias: %q, %v", got, ok)
+	}
+	if _, err := reg.Subset("missing"); err == nil {
+		t.Error("Subset of unknown tool succeeded")
+	}
+}
+
+func TestSystemPromptDeterministic(t *testing.T) {
+	reg := NewRegistry().
+		MustRegister("b.tool", strings.ToUpper, "b").
+		MustRegister("a.tool", strings.ToLower, "a", Alias("lower")).
+		MustRegister("c", strings.TrimSpace, "c")
+	agt := NewWithRegistry(nil, nil, reg)
+
+	want := agt.SystemPrompt()
+	for range 20 {
+		if got := agt.SystemPrompt(); got != want {
+			t.Fatalf("system prompt changed between calls:\n%s\n---\n%s", want, got)
+		}
+	}
+	if !strings.Contains(want, "[a.tool b.tool c]") || !strings.Contains(want, "别名：lower") {
+		t.Errorf("unexpected system prompt:\n%s", want)
+	}
+}
+
+func TestIterResolvesAlias(t *testing.T) {
+	reg := NewRegistry().MustRegister("text.upper", strings.ToUpper, "", Alias("upper"))
+	agt := NewWithRegistry(&fakeClient{replies: []string{
+		"思考：转成大写\n动作：upper\n动作输入：abc",
+		"最终答案：ABC",
+	}}, nil, reg)
+
+	it, ch := agt.Iter(nil, "问题")
+	var out strings.Builder
+	for chunk := range it {
+		out.WriteString(chunk)
+	}
+	<-ch
+	if !strings.Contains(out.String(), "观察：ABC") {
+		t.Errorf("alias not resolved:\n%s", out.String())
+	}
+}
+
+func TestIterToolSubset(t *testing.T) {
+	reg := NewRegistry().
+		MustRegister("text.upper", strings.ToUpper, "").
+		MustRegister("text.lower", strings.ToLower, "").
+		MustRegister("web.get", strings.TrimSpace, "")
+	client := &fakeClient{}
+	agt := NewWithRegistry(client, nil, reg)
+
+	run := func(history []openai.Message, reply string, opts ...RunOption) []openai.Message {
+		client.replies = []string{reply, "最终答案：好"}
+		it, ch := agt.IterContext(context.Background(), history, "问题", opts...)
+		for range it {
+		}
+		return (<-ch).Messages
+	}
+
+	msgs := run(nil, "动作：web.get\n动作输入：x", WithoutTools("web"), WithTools("text"))
+	if prompt := msgs[0].Content; strings.Contains(prompt, "web.get") || !strings.Contains(prompt, "[text.lower text.upper]") {
+		t.Errorf("system prompt does not match the run's tools:\n%s", prompt)
+	}
+	if !strings.Contains(msgs[3].Content, "找不到工具 'web.get'") {
+		t.Errorf("disabled tool was callable: %q", msgs[3].Content)
+	}
+
+	// 下一轮全部可用：历史中的 system prompt 被替换，而不是保留上一轮的
+	reg.Remove("text.lower")
+	msgs = run(msgs, "动作：web.get\n动作输入：x", WithInstructions("请简短回答"))
+	if prompt := msgs[0].Content; !strings.Contains(prompt, "[text.upper web.get]") || !strings.HasSuffix(prompt, "请简短回答") {
+		t.Errorf("system prompt not regenerated:\n%s", prompt)
+	}
+	if n := slices.IndexFunc(msgs, func(m openai.Message) bool { return m.Role == "system" }); n != 0 || strings.Count(msgs[0].Content, "ReAct Agent") != 1 {
+		t.Errorf("unexpected history: %+v", msgs)
+	}
+}
+
+func TestRegistryConcurrentChanges(t *testing.T) {
+	reg := NewRegistry().MustRegister("text.upper", strings.ToUpper, "")
+	agt := NewWithRegistry(nil, nil, reg)
+
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			name := fmt.Sprintf("tool%d", i)
+			for range 100 {
+				reg.Register(name, strings.ToLower, "")
+				agt.SystemPrompt()
+				reg.Without("text")
+				reg.Remove(name)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := reg.Names(); !slices.Equal(got, []string{"text.upper"}) {
+		t.Errorf("Names() = %v", got)
+	}
+}
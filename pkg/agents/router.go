@@ -0,0 +1,206 @@
+package agents
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/eastlaugh/agent/pkg/openai"
+)
+
+// Route 是 Router 可以选择的一个 Agent
+type Route struct {
+	// Name 须满足 ValidToolName，出现在 Event.Agent 与转交工具的输入中
+	Name string
+	// Description 说明该 Agent 擅长什么，供 LLMClassifier 与转交工具参考
+	Description string
+	Agent       *Agent
+}
+
+// Part 是 Routed.Messages 中由同一个 Agent 产生的一段
+type Part struct {
+	Agent string
+	// 该段为 Messages[From:To]，从交给该 Agent 的 user 消息开始
+	From, To int
+	// HandoffTo 为该 Agent 转交的目标，最后一段为空
+	HandoffTo string
+}
+
+// Routed 是 Router 一次运行的结果
+type Routed struct {
+	// Messages 是各 Agent 共享的对话历史，开头为最后一个 Agent 的系统提示词
+	Messages []openai.Message
+	// Parts 按顺序记录每个参与回答的 Agent 产生了哪些消息
+	Parts []Part
+	// Stop 与 Err 来自最后一个 Agent 的运行，见 Transcript
+	Stop StopReason
+	Err  error
+}
+
+// Answer 返回最后一个 Agent 的最终答案，没有时返回空字符串
+func (r Routed) Answer() string {
+	return lastAnswer(r.Messages)
+}
+
+// DefaultMaxHandoffs 是 Router 单次运行默认允许的转交次数
+const DefaultMaxHandoffs = 3
+
+// handoffTool 是注入各 Agent 的转交工具的名称
+const handoffTool = "handoff"
+
+// Router 把问题交给若干具名 Agent 之一：先由 Classifier 选出 Agent，
+// 运行中 Agent 还可以用 handoff 工具把对话连同历史转交给另一个 Agent
+//
+//	router := agents.NewRouter(agents.LLMClassifier(client),
+//		agents.Route{Name: "search", Description: "联网搜索实时信息", Agent: searchAgent},
+//		agents.Route{Name: "math", Description: "计算与数学推导", Agent: mathAgent},
+//	)
+//	events, ch := router.Stream(ctx, history, question)
+type Router struct {
+	routes      []Route
+	classifier  Classifier
+	maxHandoffs int
+	logger      *slog.Logger
+}
+
+// NewRouter 创建 Router。classifier 选出未知的名称或出错时使用第一个 Route。
+// routes 为空、名称无效或重复时 panic
+func NewRouter(classifier Classifier, routes ...Route) *Router {
+	if len(routes) == 0 {
+		panic("agents: router without routes")
+	}
+	var names []string
+	for _, rt := range routes {
+		if !ValidToolName(rt.Name) || slices.Contains(names, rt.Name) {
+			panic(fmt.Sprintf("agents: invalid or duplicate route name %q", rt.Name))
+		}
+		if rt.Agent == nil {
+			panic(fmt.Sprintf("agents: route %q has no agent", rt.Name))
+		}
+		names = append(names, rt.Name)
+	}
+	return &Router{routes: routes, classifier: classifier, maxHandoffs: DefaultMaxHandoffs, logger: slog.Default()}
+}
+
+// WithMaxHandoffs 设置单次运行最多转交的次数，为 0 时不提供转交工具
+func (r *Router) WithMaxHandoffs(n int) *Router {
+	if n < 0 {
+		panic("agents: max handoffs must not be negative")
+	}
+	r.maxHandoffs = n
+	return r
+}
+
+// WithLogger 设置日志输出，默认为 slog.Default()
+func (r *Router) WithLogger(l *slog.Logger) *Router {
+	r.logger = l
+	return r
+}
+
+// Routes 返回全部 Route
+func (r *Router) Routes() []Route {
+	return slices.Clone(r.routes)
+}
+
+func (r *Router) route(name string) (Route, bool) {
+	i := slices.IndexFunc(r.routes, func(rt Route) bool { return rt.Name == name })
+	if i < 0 {
+		return Route{}, false
+	}
+	return r.routes[i], true
+}
+
+// Stream 选出 Agent 并运行，转交时接着运行目标 Agent，直到某个 Agent 不再转交。
+// 事件与 Agent.Stream 相同，其中顶层 Agent 的事件以 Route 名称作为 Agent；
+// opts 作用于每个参与的 Agent。无论如何结束，ch 都会交付共享的历史与各 Agent 的分工
+func (r *Router) Stream(ctx context.Context, messages []openai.Message, question string, opts ...RunOption) (iter.Seq[Event], <-chan Routed) {
+	ch := make(chan Routed, 1)
+	var consumed bool
+	return func(yield func(Event) bool) {
+		if consumed {
+			panic("agents: consumed iterator")
+		}
+		consumed = true
+		defer close(ch)
+
+		result := Routed{Messages: messages}
+		defer func() { ch <- result }()
+
+		name, err := r.classifier.Classify(ctx, messages, question, r.routes)
+		current, ok := r.route(name)
+		if err != nil || !ok {
+			current = r.routes[0]
+			r.logger.WarnContext(ctx, "route classification failed, using the first route",
+				slog.String("chosen", name), slog.Any("error", err), slog.String("route", current.Name))
+		} else {
+			r.logger.DebugContext(ctx, "question routed", slog.String("route", current.Name))
+		}
+
+		for handoffs := 0; ; handoffs++ {
+			var target, reason string
+			runOpts := slices.Clone(opts)
+			if handoffs < r.maxHandoffs && len(r.routes) > 1 {
+				runOpts = append(runOpts, func(c *runConfig) {
+					c.extraTools = append(c.extraTools, r.handoff(current.Name, &target, &reason))
+					c.halt = func() bool { return target != "" }
+				})
+			}
+
+			// Stream 会在没有系统提示词时插入一条，From 指向随后追加的 user 消息
+			from := len(result.Messages)
+			if from == 0 || result.Messages[0].Role != "system" {
+				from++
+			}
+			events, done := current.Agent.Stream(ctx, result.Messages, question, runOpts...)
+			stopped := false
+			for ev := range events {
+				if ev.Depth == 0 {
+					ev.Agent = current.Name
+				}
+				if !yield(ev) {
+					stopped = true
+					break
+				}
+			}
+			// 消费者提前停止时当前 Agent 同样交付到停止处为止的历史
+			t := <-done
+			result.Messages, result.Stop, result.Err = t.Messages, t.Stop, t.Err
+			result.Parts = append(result.Parts, Part{Agent: current.Name, From: from, To: len(t.Messages), HandoffTo: target})
+			if stopped || t.Stop != StopHandoff {
+				return
+			}
+
+			r.logger.DebugContext(ctx, "handoff", slog.String("from", current.Name), slog.String("to", target), slog.String("reason", reason))
+			question = fmt.Sprintf("%s 把对话转交给了你，原因：%s。请接着回答用户最初的问题。", current.Name, reason)
+			current, _ = r.route(target)
+		}
+	}, ch
+}
+
+// handoff 返回 from 使用的转交工具，成功时把目标与原因写入 target 与 reason
+func (r *Router) handoff(from string, target, reason *string) tool {
+	var lines []string
+	for _, rt := range r.routes {
+		if rt.Name != from {
+			lines = append(lines, fmt.Sprintf("%s：%s", rt.Name, rt.Description))
+		}
+	}
+	desc := "当问题更适合由其他 Agent 处理时，把对话连同历史转交给它，转交后你将不再回答。可选的 Agent：\n// " +
+		strings.Join(lines, "\n// ")
+	return tool{
+		Name:        handoffTool,
+		Description: desc,
+		Params:      "(agent string, reason string)",
+		Handler: func(_ context.Context, input string) (string, error) {
+			name, why, _ := strings.Cut(strings.TrimSpace(input), " ")
+			if _, ok := r.route(name); !ok || name == from {
+				return "", fmt.Errorf("没有可以转交的 Agent %q", name)
+			}
+			*target, *reason = name, strings.TrimSpace(why)
+			return "已转交给 " + name, nil
+		},
+	}
+}
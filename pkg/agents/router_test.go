@@ -0,0 +1,122 @@
+package agents
+
+import (
+	"context"
+	"iter"
+	"strings"
+	"testing"
+
+	"github.com/eastlaugh/agent/pkg/openai"
+)
+
+// recordingClient 记录每次请求收到的消息
+type recordingClient struct {
+	fakeClient
+	seen [][]openai.Message
+}
+
+func (c *recordingClient) ChatStream(ctx context.Context, messages []openai.Message, stop []string) (iter.Seq[string], error) {
+	c.seen = append(c.seen, messages)
+	return c.fakeClient.ChatStream(ctx, messages, stop)
+}
+
+func TestRouterRules(t *testing.T) {
+	search := New(&fakeClient{replies: []string{"最终答案：搜到了"}}, nil)
+	math := New(&fakeClient{replies: []string{"最终答案：2"}}, nil)
+	router := NewRouter(RuleClassifier("search", Keywords("math", "计算", "sum")),
+		Route{Name: "search", Agent: search},
+		Route{Name: "math", Agent: math},
+	)
+
+	events, ch := router.Stream(context.Background(), nil, "请计算 1+1")
+	for ev := range events {
+		if ev.Agent != "math" {
+			t.Errorf("event from %q", ev.Agent)
+		}
+	}
+	res := <-ch
+	if len(res.Parts) != 1 || res.Parts[0].Agent != "math" || res.Answer() != "2" {
+		t.Errorf("result = %+v", res)
+	}
+	if got := res.Messages[res.Parts[0].From]; got.Role != "user" || got.Content != "请计算 1+1" {
+		t.Errorf("part starts at %+v", got)
+	}
+}
+
+func TestRouterHandoff(t *testing.T) {
+	search := New(&fakeClient{replies: []string{"思考：这是算术\n动作：handoff\n动作输入：math 需要计算"}}, nil)
+	mathClient := &recordingClient{fakeClient: fakeClient{replies: []string{"最终答案：2"}}}
+	math := New(mathClient, nil)
+	router := NewRouter(RuleClassifier("search"),
+		Route{Name: "search", Description: "联网搜索", Agent: search},
+		Route{Name: "math", Description: "计算", Agent: math},
+	)
+
+	events, ch := router.Stream(context.Background(), nil, "1+1 等于几")
+	var agents []string
+	for ev := range events {
+		if len(agents) == 0 || agents[len(agents)-1] != ev.Agent {
+			agents = append(agents, ev.Agent)
+		}
+	}
+	res := <-ch
+	if strings.Join(agents, ",") != "search,math" {
+		t.Errorf("agents = %v", agents)
+	}
+	if len(res.Parts) != 2 || res.Parts[0].HandoffTo != "math" || res.Parts[1].Agent != "math" || res.Answer() != "2" {
+		t.Fatalf("result = %+v", res)
+	}
+	if res.Parts[0].To != res.Parts[1].From || res.Parts[1].To != len(res.Messages) {
+		t.Errorf("parts = %+v over %d messages", res.Parts, len(res.Messages))
+	}
+
+	// math sees the shared history, including the handoff, under its own system prompt
+	seen := mathClient.seen[0]
+	var history strings.Builder
+	for _, m := range seen {
+		history.WriteString(m.Content + "\n")
+	}
+	for _, want := range []string{"1+1 等于几", "观察：已转交给 math", "search 把对话转交给了你，原因：需要计算"} {
+		if !strings.Contains(history.String(), want) {
+			t.Errorf("math's history lacks %q:\n%s", want, history.String())
+		}
+	}
+	if !strings.Contains(seen[0].Content, "search：联网搜索") || strings.Contains(seen[0].Content, "math：计算") {
+		t.Error("handoff targets should be the other agents only")
+	}
+}
+
+func TestRouterStopEarly(t *testing.T) {
+	search := New(&fakeClient{replies: []string{"思考：我需要想一想很久很久\n最终答案：42"}}, nil)
+	router := NewRouter(RuleClassifier("search"), Route{Name: "search", Agent: search})
+
+	events, ch := router.Stream(context.Background(), nil, "问题")
+	var n int
+	for range events {
+		if n++; n == 3 {
+			break
+		}
+	}
+	res := <-ch
+	if res.Stop != StopConsumer || len(res.Parts) != 1 || res.Parts[0].Agent != "search" {
+		t.Fatalf("result = %+v", res)
+	}
+	if len(res.Messages) != 3 || res.Messages[2].Content != "思考：" {
+		t.Errorf("messages = %v", res.Messages)
+	}
+}
+
+func TestLLMClassifier(t *testing.T) {
+	routes := []Route{{Name: "search"}, {Name: "math"}}
+	for reply, want := range map[string]string{
+		"math":         "math",
+		"`search`。":    "search",
+		"应该交给 math 处理": "math",
+		"不知道":          "",
+	} {
+		got, err := LLMClassifier(&fakeClient{replies: []string{reply}}).Classify(context.Background(), nil, "问题", routes)
+		if got != want || (want == "") != (err != nil) {
+			t.Errorf("reply %q: got %q, %v", reply, got, err)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package agents
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DefaultMaxDepth 是子 Agent 默认允许的最大嵌套深度，防止 Agent 互相调用时无限递归
+const DefaultMaxDepth = 3
+
+type depthKey struct{}
+
+type emitterKey struct{}
+
+// runDepth 返回 ctx 所在运行的嵌套深度，顶层运行为 0
+func runDepth(ctx context.Context) int {
+	d, _ := ctx.Value(depthKey{}).(int)
+	return d
+}
+
+// withEmitter 把运行的事件输出交给工具，子 Agent 经由它把自己的步骤插入父 Agent 的事件流
+func withEmitter(ctx context.Context, emit func(Event) bool) context.Context {
+	return context.WithValue(ctx, emitterKey{}, emit)
+}
+
+func emitterFrom(ctx context.Context) func(Event) bool {
+	emit, _ := ctx.Value(emitterKey{}).(func(Event) bool)
+	return emit
+}
+
+// SubAgentOption 调整 AsTool 返回的工具
+type SubAgentOption func(*subAgent)
+
+type subAgent struct {
+	maxSteps int
+	timeout  time.Duration
+	maxDepth int
+}
+
+// SubAgentMaxSteps 限制子 Agent 每次被调用时最多执行的步数，默认沿用子 Agent 的 WithMaxSteps
+func SubAgentMaxSteps(n int) SubAgentOption {
+	if n <= 0 {
+		panic("agents: max steps must be positive")
+	}
+	return func(s *subAgent) { s.maxSteps = n }
+}
+
+// SubAgentTimeout 限制子 Agent 每次被调用的耗时，超时后停止子 Agent，并把超时作为失败的观察交给父 Agent
+func SubAgentTimeout(d time.Duration) SubAgentOption {
+	return func(s *subAgent) { s.timeout = d }
+}
+
+// SubAgentMaxDepth 设置子 Agent 所在的最大嵌套深度，直接被顶层 Agent 调用时深度为 1，默认为 DefaultMaxDepth
+func SubAgentMaxDepth(n int) SubAgentOption {
+	if n <= 0 {
+		panic("agents: max depth must be positive")
+	}
+	return func(s *subAgent) { s.maxDepth = n }
+}
+
+// AsTool 把 Agent 包装成工具，动作输入作为子 Agent 的问题，观察只包含子 Agent 的最终答案。
+// 子 Agent 的每一步按嵌套深度标记后插入父 Agent 的 Stream 事件流；子 Agent 没有给出最终答案时
+// （超时、超过步数、嵌套过深、模型请求失败），工具以失败结束。
+//
+// 每次调用都从空白对话开始，使用子 Agent 自己的工具；父运行的 WithTools、WithApproval 等选项不作用于子 Agent
+//
+//	researcher := agents.NewWithRegistry(client, nil, webTools).WithName("researcher")
+//	reg.MustRegister("researcher", researcher.AsTool(agents.SubAgentTimeout(time.Minute)), "调研一个问题并给出结论")
+func (a *Agent) AsTool(opts ...SubAgentOption) Handler {
+	sa := subAgent{maxDepth: DefaultMaxDepth}
+	for _, opt := range opts {
+		opt(&sa)
+	}
+	return func(ctx context.Context, input string) (answer string, err error) {
+		depth := runDepth(ctx) + 1
+		if depth > sa.maxDepth {
+			return "", fmt.Errorf("子 Agent 嵌套超过 %d 层", sa.maxDepth)
+		}
+		parent := ctx
+		ctx = context.WithValue(ctx, depthKey{}, depth)
+		if sa.timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, sa.timeout)
+			defer cancel()
+		}
+		var runOpts []RunOption
+		if sa.maxSteps > 0 {
+			runOpts = append(runOpts, func(c *runConfig) { c.maxSteps = sa.maxSteps })
+		}
+
+		emit := emitterFrom(parent)
+		events, ch := a.Stream(ctx, nil, input, runOpts...)
+		var stopped bool
+		for ev := range events {
+			if emit != nil && !emit(ev) {
+				stopped = true
+				break
+			}
+		}
+		t := <-ch
+		switch {
+		case t.Err != nil:
+			// 超过步数与模型请求失败时转为工具失败
+			return "", t.Err
+		case parent.Err() != nil:
+			return "", parent.Err()
+		case errors.Is(ctx.Err(), context.DeadlineExceeded):
+			return "", fmt.Errorf("子 Agent 超过 %v 仍未完成", sa.timeout)
+		case stopped:
+			return "", errors.New("父 Agent 的消费者已停止")
+		}
+		messages := t.Messages
+		if n := len(messages); n > 0 && messages[n-1].Role == "assistant" {
+			if answer, ok := finalAnswer(messages[n-1].Content); ok {
+				return answer, nil
+			}
+		}
+		return "", errors.New("子 Agent 没有给出最终答案")
+	}
+}
+
+// finalAnswer 返回回复中“最终答案：”之后的全部内容
+func finalAnswer(text string) (string, bool) {
+	const marker = "最终答案："
+	i := strings.Index(text, marker)
+	if i < 0 {
+		return "", false
+	}
+	return strings.TrimSpace(text[i+len(marker):]), true
+}
@@ -0,0 +1,135 @@
+package agents
+
+import (
+	"context"
+	"iter"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eastlaugh/agent/pkg/openai"
+)
+
+func TestSubAgent(t *testing.T) {
+	expert := NewWithRegistry(&fakeClient{replies: []string{
+		"思考：想一想\n最终答案：生命的意义\n是 42",
+	}}, nil, NewRegistry()).WithName("expert")
+	reg := NewRegistry().MustRegister("expert", expert.AsTool(), "回答难题")
+	parent := NewWithRegistry(&fakeClient{replies: []string{
+		"思考：问专家\n动作：expert\n动作输入：什么是 42",
+		"最终答案：专家说是 42",
+	}}, nil, reg)
+
+	events, ch := parent.Stream(context.Background(), nil, "问题")
+	var answers []string
+	var seq []int
+	for state, ev := range ReactEvents(events) {
+		if len(seq) == 0 || seq[len(seq)-1] != ev.Depth {
+			seq = append(seq, ev.Depth)
+		}
+		if ev.Depth == 1 && ev.Agent != "expert" {
+			t.Errorf("depth 1 event from agent %q", ev.Agent)
+		}
+		if state == Answering {
+			answers = append(answers, strings.TrimSpace(ev.Text))
+		}
+	}
+	msgs := (<-ch).Messages
+
+	// the child's steps sit between the parent's action and observation
+	if got := seq; len(got) != 3 || got[0] != 0 || got[1] != 1 || got[2] != 0 {
+		t.Errorf("depth sequence = %v, want [0 1 0]", got)
+	}
+	if len(answers) != 2 || answers[0] != "生命的意义\n是 42" || answers[1] != "专家说是 42" {
+		t.Errorf("answers = %q", answers)
+	}
+	// the observation is only the child's final answer
+	if got := msgs[3].Content; got != "观察：生命的意义\n是 42" {
+		t.Errorf("observation = %q", got)
+	}
+}
+
+func TestSubAgentIterContext(t *testing.T) {
+	expert := New(&fakeClient{replies: []string{"思考：秘密\n最终答案：42"}}, nil)
+	parent := NewWithRegistry(&fakeClient{replies: []string{
+		"动作：expert\n动作输入：问",
+		"最终答案：42",
+	}}, nil, NewRegistry().MustRegister("expert", expert.AsTool(), ""))
+	it, ch := parent.Iter(nil, "问题")
+	var out strings.Builder
+	for chunk := range it {
+		out.WriteString(chunk)
+	}
+	<-ch
+	if strings.Contains(out.String(), "秘密") {
+		t.Errorf("IterContext includes the child's steps:\n%s", out.String())
+	}
+}
+
+func TestSubAgentDepthGuard(t *testing.T) {
+	// one client serves every level, in call order
+	client := &fakeClient{replies: []string{
+		"动作：self\n动作输入：a", // depth 0
+		"动作：self\n动作输入：b", // depth 1
+		"动作：self\n动作输入：c", // depth 2, rejected
+		"最终答案：d2",
+		"最终答案：d1",
+		"最终答案：d0",
+	}}
+	reg := NewRegistry()
+	agt := NewWithRegistry(client, nil, reg)
+	reg.MustRegister("self", agt.AsTool(SubAgentMaxDepth(2)), "")
+
+	events, ch := agt.Stream(context.Background(), nil, "问题")
+	var rejected bool
+	for ev := range events {
+		if ev.Depth == 2 && strings.Contains(ev.Text, "嵌套超过 2 层") {
+			rejected = true
+		}
+	}
+	msgs := (<-ch).Messages
+	if !rejected {
+		t.Error("depth 3 was not rejected")
+	}
+	if got := msgs[len(msgs)-1].Content; got != "最终答案：d0" {
+		t.Errorf("last message = %q", got)
+	}
+}
+
+// blockingClient streams nothing until ctx ends.
+type blockingClient struct{}
+
+func (blockingClient) Chat(ctx context.Context, _ []openai.Message, _ []string) (string, error) {
+	<-ctx.Done()
+	return "", ctx.Err()
+}
+
+func (blockingClient) ChatStream(ctx context.Context, _ []openai.Message, _ []string) (iter.Seq[string], error) {
+	return func(yield func(string) bool) { <-ctx.Done() }, nil
+}
+
+func TestSubAgentBudgets(t *testing.T) {
+	slow := New(blockingClient{}, nil)
+	looping := New(&fakeClient{replies: []string{
+		"动作：nothing\n动作输入：",
+		"动作：nothing\n动作输入：",
+	}}, nil)
+	reg := NewRegistry().
+		MustRegister("slow", slow.AsTool(SubAgentTimeout(20*time.Millisecond)), "").
+		MustRegister("looping", looping.AsTool(SubAgentMaxSteps(1)), "")
+	parent := NewWithRegistry(&fakeClient{replies: []string{
+		"动作：slow\n动作输入：",
+		"动作：looping\n动作输入：",
+		"最终答案：放弃",
+	}}, nil, reg)
+
+	it, ch := parent.Iter(nil, "问题")
+	for range it {
+	}
+	msgs := (<-ch).Messages
+	for i, want := range map[int]string{3: "超过 20ms 仍未完成", 5: "达到最大步数"} {
+		if got := msgs[i].Content; !strings.Contains(got, want) {
+			t.Errorf("observation %d = %q, want it to contain %q", i, got, want)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package agents
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/eastlaugh/agent/pkg/openai"
+	"github.com/eastlaugh/agent/pkg/util"
+)
+
+// DefaultTypedRetries 是 RunTyped 在最终答案不符合类型时默认允许的重试次数
+const DefaultTypedRetries = 3
+
+// WithTypedRetries 设置 RunTyped 在最终答案不符合类型时最多让模型重试几次，默认为 DefaultTypedRetries
+func WithTypedRetries(n int) RunOption {
+	if n < 0 {
+		panic("agents: typed retries must not be negative")
+	}
+	return func(c *runConfig) { c.typedRetries = &n }
+}
+
+// RunTyped 运行 agt，并要求模型以符合 T 的 JSON Schema（见 util.JSONSchema）的 JSON 给出最终答案。
+// 答案无法解析或不符合 Schema 时，错误作为观察交给模型重试，重试用尽后返回错误；
+// 运行被取消或出错（包括超过最大步数）时同样返回错误。messages 为本次运行结束时的历史
+//
+//	type contact struct {
+//		Name  string `json:"name"`
+//		Email string `json:"email,omitempty" desc:"没有时留空"`
+//	}
+//	c, history, err := agents.RunTyped[contact](ctx, agt, history, "从这封邮件中提取联系人：...")
+func RunTyped[T any](ctx context.Context, agt *Agent, messages []openai.Message, question string, opts ...RunOption) (result T, history []openai.Message, err error) {
+	schema := util.JSONSchema(reflect.TypeFor[T]())
+	schemaJSON, _ := json.MarshalIndent(schema, "", "  ")
+
+	var cfg runConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	retries := DefaultTypedRetries
+	if cfg.typedRetries != nil {
+		retries = *cfg.typedRetries
+	}
+
+	opts = append(opts, WithInstructions(fmt.Sprintf("最终答案必须是符合以下 JSON Schema 的 JSON，“最终答案：”之后不要有其他文字：\n%s", schemaJSON)))
+	if retries > 0 {
+		opts = append(opts, func(c *runConfig) {
+			c.checks = append(c.checks, answerCheck{name: "schema", marker: "观察：", max: retries, check: func(_ context.Context, _ []openai.Message, answer string) (string, error) {
+				var v T
+				if err := decodeTyped(schema, answer, &v); err != nil {
+					return fmt.Sprintf("最终答案不符合要求的 JSON Schema：%v", err), nil
+				}
+				return "", nil
+			}})
+		})
+	}
+
+	it, ch := agt.Stream(ctx, messages, question, opts...)
+	for range it {
+	}
+	t := <-ch
+	history = t.Messages
+	if err := ctx.Err(); err != nil {
+		return result, history, err
+	}
+	if t.Err != nil {
+		return result, history, fmt.Errorf("agents: %w", t.Err)
+	}
+	if n := len(history); n > 0 && history[n-1].Role == "assistant" {
+		if answer, ok := finalAnswer(history[n-1].Content); ok {
+			if err := decodeTyped(schema, answer, &result); err != nil {
+				return result, history, fmt.Errorf("agents: final answer does not match %T: %w", result, err)
+			}
+			return result, history, nil
+		}
+	}
+	return result, history, errors.New("agents: run ended without a final answer")
+}
+
+// decodeTyped 校验并解码最终答案，允许答案被包在 ``` 代码块中
+func decodeTyped(schema map[string]any, answer string, v any) error {
+	answer = strings.TrimSpace(answer)
+	if rest, ok := strings.CutPrefix(answer, "```"); ok {
+		// 去掉语言标记所在的第一行与结尾的 ```
+		if _, body, ok := strings.Cut(rest, "\n"); ok {
+			answer = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(body), "```"))
+		}
+	}
+	var raw any
+	if err := json.Unmarshal([]byte(answer), &raw); err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+	if err := util.ValidateJSON(schema, raw); err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(answer), v)
+}
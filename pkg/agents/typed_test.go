@@ -0,0 +1,49 @@
+package agents
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+type contact struct {
+	Name  string   `json:"name"`
+	Age   int      `json:"age"`
+	Tags  []string `json:"tags,omitempty"`
+	Email *string  `json:"email"`
+}
+
+func TestRunTyped(t *testing.T) {
+	client := &recordingClient{fakeClient: fakeClient{replies: []string{
+		"最终答案：Alice，28 岁",
+		`最终答案：{"name": "Alice", "age": "28"}`,
+		"最终答案：\n```json\n{\"name\": \"Alice\", \"age\": 28, \"email\": null}\n```",
+	}}}
+	c, history, err := RunTyped[contact](context.Background(), New(client, nil), nil, "提取联系人")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Name != "Alice" || c.Age != 28 || c.Email != nil {
+		t.Errorf("contact = %+v", c)
+	}
+	if !strings.Contains(client.seen[0][0].Content, `"required": [`) {
+		t.Errorf("system prompt lacks the schema:\n%s", client.seen[0][0].Content)
+	}
+	// each failure is fed back as an observation
+	for i, want := range map[int]string{3: "invalid JSON", 5: "$.age: expected integer, got string"} {
+		if got := history[i].Content; !strings.HasPrefix(got, "观察：") || !strings.Contains(got, want) {
+			t.Errorf("message %d = %q, want an observation containing %q", i, got, want)
+		}
+	}
+}
+
+func TestRunTypedRetriesExhausted(t *testing.T) {
+	client := &fakeClient{replies: []string{`最终答案：{"name": "Bob"}`, `最终答案：{"name": "Bob", "age": 30, "extra": 1}`}}
+	_, history, err := RunTyped[contact](context.Background(), New(client, nil), nil, "提取联系人", WithTypedRetries(1))
+	if err == nil || !strings.Contains(err.Error(), `unknown property "extra"`) {
+		t.Errorf("err = %v", err)
+	}
+	if len(history) != 5 {
+		t.Errorf("history has %d messages, want 5", len(history))
+	}
+}
@@ -0,0 +1,241 @@
+// Package audit keeps a tamper-evident, append-only record of the tools an
+// agent executed.
+//
+// Each line of the log is a JSON Entry. An entry's Hash is the SHA-256 of the
+// previous entry's hash followed by the entry itself (with Hash empty), so
+// editing, removing or reordering entries breaks the chain after them.
+// Verify checks the chain; cmd/auditverify runs it from the command line.
+//
+//	log, err := audit.Open("audit.jsonl")
+//	...
+//	agt.Use(log.Middleware())
+package audit
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/eastlaugh/agent/pkg/agents"
+	"github.com/eastlaugh/agent/pkg/logging"
+)
+
+// DefaultMaxOutput is the default number of output bytes kept per entry.
+const DefaultMaxOutput = 1024
+
+// Outcomes of a tool call.
+const (
+	OutcomeOK     = "ok"
+	OutcomeFailed = "failed"
+	OutcomePanic  = "panic"
+)
+
+// Entry is one audited tool call.
+type Entry struct {
+	Seq            int64     `json:"seq"`
+	Time           time.Time `json:"time"`
+	ConversationID string    `json:"conversation_id,omitempty"`
+	RunID          string    `json:"run_id,omitempty"`
+	// User identifies who the agent acted for, e.g. the API key name.
+	User string `json:"user,omitempty"`
+	Tool string `json:"tool"`
+	Args string `json:"args"`
+	// Output is truncated to the log's MaxOutput bytes.
+	Output     string `json:"output"`
+	Truncated  bool   `json:"truncated,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+	Outcome    string `json:"outcome"`
+	// Prev is the Hash of the previous entry, empty for the first one.
+	Prev string `json:"prev"`
+	Hash string `json:"hash"`
+}
+
+// sum returns the chain hash of e, which covers every field but Hash.
+func (e Entry) sum() string {
+	e.Hash = ""
+	b, _ := json.Marshal(e)
+	h := sha256.New()
+	h.Write([]byte(e.Prev))
+	h.Write(b)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Log appends entries to a file. It is safe for concurrent use, but only one
+// Log (in one process) may write a given file at a time.
+type Log struct {
+	// MaxOutput is the number of output bytes kept per entry.
+	MaxOutput int
+	// Logger reports entries that could not be written.
+	Logger *slog.Logger
+
+	mu   sync.Mutex
+	f    *os.File
+	seq  int64
+	prev string
+	now  func() time.Time
+}
+
+// Open opens the log at path, creating it if needed, and continues the chain
+// from its last entry. It fails if the existing file does not verify.
+func Open(path string) (*Log, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	last, _, err := verify(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("audit: %s: %w", path, err)
+	}
+	return &Log{
+		MaxOutput: DefaultMaxOutput,
+		Logger:    slog.Default(),
+		f:         f,
+		seq:       last.Seq,
+		prev:      last.Hash,
+		now:       time.Now,
+	}, nil
+}
+
+// Append fills in e's Seq, Prev and Hash, writes it and syncs the file.
+func (l *Log) Append(e Entry) (Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return e, errors.New("audit: log is closed")
+	}
+	e.Seq, e.Prev = l.seq+1, l.prev
+	e.Hash = e.sum()
+	b, err := json.Marshal(e)
+	if err != nil {
+		return e, err
+	}
+	if _, err := l.f.Write(append(b, '\n')); err != nil {
+		return e, err
+	}
+	if err := l.f.Sync(); err != nil {
+		return e, err
+	}
+	l.seq, l.prev = e.Seq, e.Hash
+	return e, nil
+}
+
+// Close closes the underlying file.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
+
+// Middleware records every tool call that reaches it. Add it with Agent.Use;
+// calls answered from the tool cache never run the tool and are not recorded.
+// The conversation, run and user come from the "conversation_id", "run_id"
+// and "user" attributes set with logging.WithAttrs. Arguments and output of
+// Sensitive tools are recorded as logging.Redacted.
+func (l *Log) Middleware() agents.ToolMiddleware {
+	return func(next agents.ToolFunc) agents.ToolFunc {
+		return func(ctx context.Context, req agents.ToolRequest) (res agents.ToolResult) {
+			start := l.now()
+			defer func() {
+				outcome := OutcomeOK
+				if res.Failed {
+					outcome = OutcomeFailed
+				}
+				r := recover()
+				if r != nil {
+					outcome, res.Output = OutcomePanic, fmt.Sprint(r)
+				}
+				l.record(ctx, req, res.Output, outcome, start)
+				if r != nil {
+					panic(r)
+				}
+			}()
+			return next(ctx, req)
+		}
+	}
+}
+
+func (l *Log) record(ctx context.Context, req agents.ToolRequest, output, outcome string, start time.Time) {
+	e := Entry{
+		Time:       start.UTC(),
+		Tool:       req.Tool.Name,
+		Args:       req.Input,
+		DurationMS: l.now().Sub(start).Milliseconds(),
+		Outcome:    outcome,
+	}
+	for _, a := range logging.Attrs(ctx) {
+		switch a.Key {
+		case "conversation_id":
+			e.ConversationID = a.Value.String()
+		case "run_id":
+			e.RunID = a.Value.String()
+		case "user":
+			e.User = a.Value.String()
+		}
+	}
+	if req.Tool.Sensitive {
+		e.Args, output = logging.Redacted, logging.Redacted
+	}
+	e.Output, e.Truncated = truncate(output, l.MaxOutput)
+	if _, err := l.Append(e); err != nil {
+		l.Logger.ErrorContext(ctx, "audit write failed", "tool", req.Tool.Name, "error", err)
+	}
+}
+
+// truncate cuts s to at most n bytes without splitting a rune.
+func truncate(s string, n int) (string, bool) {
+	if n <= 0 || len(s) <= n {
+		return s, false
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n], true
+}
+
+// Verify checks that every entry in r is intact and linked to the one before
+// it. It returns the number of entries checked and the hash of the last one;
+// on failure the error names the first bad line. The chain cannot reveal
+// entries cut from the end of the log, so keep the returned head elsewhere
+// and compare it with later runs.
+func Verify(r io.Reader) (n int, head string, err error) {
+	last, n, err := verify(r)
+	return n, last.Hash, err
+}
+
+func verify(r io.Reader) (last Entry, n int, err error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 16<<20)
+	for sc.Scan() {
+		line := n + 1
+		var e Entry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return last, n, fmt.Errorf("line %d: %w", line, err)
+		}
+		switch {
+		case e.Seq != last.Seq+1:
+			return last, n, fmt.Errorf("line %d: seq %d, want %d", line, e.Seq, last.Seq+1)
+		case e.Prev != last.Hash:
+			return last, n, fmt.Errorf("line %d: chain broken, previous hash does not match", line)
+		case e.Hash != e.sum():
+			return last, n, fmt.Errorf("line %d: hash mismatch, entry was modified", line)
+		}
+		last, n = e, line
+	}
+	return last, n, sc.Err()
+}
@@ -0,0 +1,105 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/eastlaugh/agent/pkg/agents"
+	"github.com/eastlaugh/agent/pkg/logging"
+)
+
+func TestMiddleware(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.MaxOutput = 4
+	tool := func(ctx context.Context, req agents.ToolRequest) agents.ToolResult {
+		if req.Input == "boom" {
+			panic("boom")
+		}
+		return agents.ToolResult{Output: "输出很长", Failed: req.Input == "bad"}
+	}
+	run := l.Middleware()(tool)
+	ctx := logging.WithAttrs(context.Background(), slog.String("conversation_id", "c1"), slog.String("user", "alice"))
+	run(ctx, agents.ToolRequest{Tool: agents.ToolInfo{Name: "search"}, Input: "go"})
+	run(ctx, agents.ToolRequest{Tool: agents.ToolInfo{Name: "search"}, Input: "bad"})
+	run(ctx, agents.ToolRequest{Tool: agents.ToolInfo{Name: "login", Sensitive: true}, Input: "secret"})
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("panic not propagated")
+			}
+		}()
+		run(ctx, agents.ToolRequest{Tool: agents.ToolInfo{Name: "search"}, Input: "boom"})
+	}()
+	l.Close()
+
+	// Reopening continues the chain.
+	l, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Middleware()(tool)(ctx, agents.ToolRequest{Tool: agents.ToolInfo{Name: "search"}, Input: "again"})
+	l.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, head, err := Verify(bytes.NewReader(data))
+	if err != nil || n != 5 || head == "" {
+		t.Fatalf("Verify = %d, %q, %v", n, head, err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	for i, want := range []string{
+		`"conversation_id":"c1","user":"alice","tool":"search","args":"go","output":"输","truncated":true`,
+		`"outcome":"failed"`,
+		`"args":"[REDACTED]","output":"[RED","truncated":true`,
+		`"output":"boom","duration_ms":0,"outcome":"panic"`,
+		`"seq":5`,
+	} {
+		if !strings.Contains(lines[i], want) {
+			t.Errorf("line %d = %s, want %s", i+1, lines[i], want)
+		}
+	}
+}
+
+func TestVerifyTampered(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tool := range []string{"a", "b", "c"} {
+		if _, err := l.Append(Entry{Tool: tool, Outcome: OutcomeOK}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l.Close()
+	data, _ := os.ReadFile(path)
+	lines := strings.SplitAfter(string(data), "\n")
+
+	for name, log := range map[string]string{
+		"edited":    lines[0] + strings.Replace(lines[1], `"tool":"b"`, `"tool":"x"`, 1) + lines[2],
+		"removed":   lines[0] + lines[2],
+		"reordered": lines[0] + lines[2] + lines[1],
+	} {
+		if _, _, err := Verify(strings.NewReader(log)); err == nil || !strings.HasPrefix(err.Error(), "line 2:") {
+			t.Errorf("%s: err = %v", name, err)
+		}
+	}
+
+	if err := os.WriteFile(path, []byte(lines[0]+lines[2]), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(path); err == nil {
+		t.Error("opened a broken log")
+	}
+}
@@ -0,0 +1,125 @@
+// Package logging provides the slog plumbing shared by the agent packages:
+// a handler that adds attributes carried by the context (run and
+// conversation ids) to every record, and redaction of secrets.
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// Redacted replaces sensitive values in log output.
+const Redacted = "[REDACTED]"
+
+// Options configures New.
+type Options struct {
+	Level slog.Leveler
+	// JSON selects the JSON handler instead of the text handler.
+	JSON bool
+	// SensitiveKeys are extra attribute keys whose values are always
+	// redacted, in addition to DefaultSensitiveKeys. Matching is
+	// case-insensitive and also applies to keys inside groups.
+	SensitiveKeys []string
+}
+
+// DefaultSensitiveKeys are attribute keys that are always redacted.
+var DefaultSensitiveKeys = []string{"api_key", "apikey", "authorization", "password", "secret", "token", "access_token"}
+
+// secretPattern matches API keys and bearer tokens embedded in free text,
+// e.g. in an error message that echoes a request header.
+var secretPattern = regexp.MustCompile(`(?i)\b(sk-[a-z0-9_\-]{8,}|bearer\s+[a-z0-9._\-]{8,})`)
+
+// New returns a logger writing to w that redacts secrets and includes the
+// attributes attached to the context with WithAttrs.
+func New(w io.Writer, opts Options) *slog.Logger {
+	hopts := &slog.HandlerOptions{Level: opts.Level, ReplaceAttr: Redact(opts.SensitiveKeys...)}
+	var h slog.Handler
+	if opts.JSON {
+		h = slog.NewJSONHandler(w, hopts)
+	} else {
+		h = slog.NewTextHandler(w, hopts)
+	}
+	return slog.New(NewContextHandler(h))
+}
+
+// Discard returns a logger that drops everything.
+func Discard() *slog.Logger {
+	return slog.New(slog.DiscardHandler)
+}
+
+// Redact returns a slog.HandlerOptions.ReplaceAttr function that hides the
+// values of sensitive keys and masks API keys found inside string values.
+func Redact(extraKeys ...string) func(groups []string, a slog.Attr) slog.Attr {
+	keys := map[string]bool{}
+	for _, k := range append(DefaultSensitiveKeys, extraKeys...) {
+		keys[strings.ToLower(k)] = true
+	}
+	return func(_ []string, a slog.Attr) slog.Attr {
+		if keys[strings.ToLower(a.Key)] {
+			return slog.String(a.Key, Redacted)
+		}
+		if a.Value.Kind() == slog.KindString {
+			if s := a.Value.String(); secretPattern.MatchString(s) {
+				return slog.String(a.Key, RedactString(s))
+			}
+		}
+		return a
+	}
+}
+
+// RedactString masks API keys and bearer tokens inside s.
+func RedactString(s string) string {
+	return secretPattern.ReplaceAllString(s, Redacted)
+}
+
+type attrsKey struct{}
+
+// WithAttrs returns a context whose log records (when logged through a
+// handler from NewContextHandler with a *Context method) carry attrs.
+func WithAttrs(ctx context.Context, attrs ...slog.Attr) context.Context {
+	parent, _ := ctx.Value(attrsKey{}).([]slog.Attr)
+	all := append(parent[:len(parent):len(parent)], attrs...)
+	return context.WithValue(ctx, attrsKey{}, all)
+}
+
+// Attrs returns the attributes attached to ctx.
+func Attrs(ctx context.Context) []slog.Attr {
+	attrs, _ := ctx.Value(attrsKey{}).([]slog.Attr)
+	return attrs
+}
+
+// ContextHandler adds the attributes from WithAttrs to every record.
+type ContextHandler struct {
+	slog.Handler
+}
+
+// NewContextHandler wraps h.
+func NewContextHandler(h slog.Handler) *ContextHandler {
+	return &ContextHandler{h}
+}
+
+func (h *ContextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if attrs := Attrs(ctx); len(attrs) > 0 {
+		r = r.Clone()
+		r.AddAttrs(attrs...)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *ContextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &ContextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h *ContextHandler) WithGroup(name string) slog.Handler {
+	return &ContextHandler{h.Handler.WithGroup(name)}
+}
+
+// ParseLevel parses "debug", "info", "warn" or "error" (case-insensitive).
+func ParseLevel(s string) (slog.Level, error) {
+	var l slog.Level
+	err := l.UnmarshalText([]byte(s))
+	return l, err
+}
@@ -0,0 +1,49 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, Options{SensitiveKeys: []string{"cookie"}})
+	logger.Info("request",
+		"api_key", "abc",
+		"Cookie", "session=1",
+		"error", "401: Authorization: Bearer abcdefghijkl rejected",
+		"url", "https://example.com",
+	)
+	out := buf.String()
+	for _, secret := range []string{"abc ", "session=1", "abcdefghijkl"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("%q leaked: %s", secret, out)
+		}
+	}
+	if !strings.Contains(out, "url=https://example.com") {
+		t.Errorf("unrelated attribute lost: %s", out)
+	}
+}
+
+func TestContextAttrs(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, Options{JSON: true})
+	ctx := WithAttrs(context.Background(), slog.String("run_id", "r1"))
+	ctx = WithAttrs(ctx, slog.String("conversation_id", "c1"))
+	logger.InfoContext(ctx, "step")
+	logger.Info("no context")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines", len(lines))
+	}
+	if !strings.Contains(lines[0], `"run_id":"r1"`) || !strings.Contains(lines[0], `"conversation_id":"c1"`) {
+		t.Errorf("missing context attrs: %s", lines[0])
+	}
+	if strings.Contains(lines[1], "run_id") {
+		t.Errorf("context attrs leaked into unrelated record: %s", lines[1])
+	}
+}
@@ -0,0 +1,142 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"sync/atomic"
+)
+
+// Transport carries JSON-RPC messages between a client and a server.
+// Implementations must be safe for concurrent use.
+type Transport interface {
+	// Call sends a request and waits for the response with the same id.
+	Call(ctx context.Context, req *Message) (*Message, error)
+	// Notify sends a notification, which has no response.
+	Notify(ctx context.Context, n *Message) error
+	// Close releases the connection; pending calls fail.
+	Close() error
+}
+
+// Client is an initialized MCP session.
+type Client struct {
+	t      Transport
+	nextID atomic.Int64
+	server InitializeResult
+}
+
+// ClientInfo is sent to servers during initialization.
+var ClientInfo = Implementation{Name: "eastlaugh-agent", Version: "0.1.0"}
+
+// NewClient performs the initialize handshake over t. The transport is
+// closed if the handshake fails.
+func NewClient(ctx context.Context, t Transport) (*Client, error) {
+	c := &Client{t: t}
+	err := c.call(ctx, "initialize", InitializeParams{
+		ProtocolVersion: ProtocolVersion,
+		Capabilities:    map[string]any{},
+		ClientInfo:      ClientInfo,
+	}, &c.server)
+	if err == nil && !SupportsVersion(c.server.ProtocolVersion) {
+		err = fmt.Errorf("mcp: unsupported protocol version %q", c.server.ProtocolVersion)
+	}
+	if err == nil {
+		if v, ok := t.(interface{ setProtocolVersion(string) }); ok {
+			v.setProtocolVersion(c.server.ProtocolVersion)
+		}
+		err = c.notify(ctx, "notifications/initialized", nil)
+	}
+	if err != nil {
+		t.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// DialStdio starts the server command and talks to it over its stdin and
+// stdout. The process is stopped by Close.
+func DialStdio(ctx context.Context, name string, args ...string) (*Client, error) {
+	t, err := NewStdioTransport(exec.Command(name, args...))
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(ctx, t)
+}
+
+// DialHTTP connects to a streamable HTTP endpoint such as
+// http://localhost:3000/mcp.
+func DialHTTP(ctx context.Context, url string) (*Client, error) {
+	return NewClient(ctx, NewHTTPTransport(url))
+}
+
+// Server describes the server, as reported during initialization.
+func (c *Client) Server() InitializeResult { return c.server }
+
+// Close ends the session.
+func (c *Client) Close() error { return c.t.Close() }
+
+func (c *Client) call(ctx context.Context, method string, params, result any) error {
+	req := &Message{JSONRPC: "2.0", Method: method, ID: json.RawMessage(strconv.FormatInt(c.nextID.Add(1), 10))}
+	if params != nil {
+		p, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		req.Params = p
+	}
+	resp, err := c.t.Call(ctx, req)
+	if err != nil {
+		return err
+	}
+	if resp.Error != nil {
+		return resp.Error
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.Unmarshal(resp.Result, result); err != nil {
+		return fmt.Errorf("mcp: %s: decoding result: %w", method, err)
+	}
+	return nil
+}
+
+func (c *Client) notify(ctx context.Context, method string, params any) error {
+	n := &Message{JSONRPC: "2.0", Method: method}
+	if params != nil {
+		p, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		n.Params = p
+	}
+	return c.t.Notify(ctx, n)
+}
+
+// ListTools returns all tools of the server, following pagination.
+func (c *Client) ListTools(ctx context.Context) ([]Tool, error) {
+	var tools []Tool
+	var cursor string
+	for {
+		var res ListToolsResult
+		if err := c.call(ctx, "tools/list", ListToolsParams{Cursor: cursor}, &res); err != nil {
+			return nil, err
+		}
+		tools = append(tools, res.Tools...)
+		if res.NextCursor == "" {
+			return tools, nil
+		}
+		cursor = res.NextCursor
+	}
+}
+
+// CallTool invokes a tool. args must be a JSON object or nil. A tool that
+// fails reports it through CallToolResult.IsError rather than an error.
+func (c *Client) CallTool(ctx context.Context, name string, args json.RawMessage) (*CallToolResult, error) {
+	var res CallToolResult
+	if err := c.call(ctx, "tools/call", CallToolParams{Name: name, Arguments: args}, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
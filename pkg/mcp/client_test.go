@@ -0,0 +1,180 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"iter"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eastlaugh/agent/pkg/agents"
+	"github.com/eastlaugh/agent/pkg/openai"
+)
+
+func dialStub(t *testing.T) *Client {
+	t.Helper()
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), "MCP_STUB_SERVER=1")
+	tr, err := NewStdioTransport(cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewClient(context.Background(), tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func testClient(t *testing.T, c *Client) {
+	ctx := context.Background()
+	if got := c.Server().ServerInfo.Name; got != "stub" {
+		t.Errorf("server name = %q", got)
+	}
+
+	tools, err := c.ListTools(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tools) != len(stubTools) {
+		t.Fatalf("got %d tools, want %d (pagination)", len(tools), len(stubTools))
+	}
+
+	res, err := c.CallTool(ctx, "echo", []byte(`{"text":"ab","repeat":2}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.IsError || res.Text() != "abab" {
+		t.Errorf("echo = %+v", res)
+	}
+
+	res, err = c.CallTool(ctx, "fail-always", nil)
+	if err != nil || !res.IsError {
+		t.Errorf("fail-always = %+v, %v", res, err)
+	}
+
+	if _, err := c.CallTool(ctx, "missing", nil); err == nil || !strings.Contains(err.Error(), "unknown tool") {
+		t.Errorf("missing tool error = %v", err)
+	}
+}
+
+func TestStdioClient(t *testing.T) {
+	testClient(t, dialStub(t))
+}
+
+func TestHTTPClient(t *testing.T) {
+	srv := httptest.NewServer(stubHTTPHandler(t))
+	defer srv.Close()
+	c, err := DialHTTP(context.Background(), srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	testClient(t, c)
+}
+
+func TestStdioCallCancel(t *testing.T) {
+	c := dialStub(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := c.CallTool(ctx, "sleep", nil); err != context.DeadlineExceeded {
+		t.Fatalf("err = %v", err)
+	}
+	// the session is still usable
+	if _, err := c.ListTools(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	c := dialStub(t)
+	reg := agents.NewRegistry()
+	names, err := c.Register(context.Background(), reg, "stub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"stub.echo", "stub.fail_always", "stub.sleep"}; !slices.Equal(names, want) {
+		t.Fatalf("names = %v, want %v", names, want)
+	}
+
+	prompt := agents.NewWithRegistry(nil, nil, reg).SystemPrompt()
+	for _, want := range []string{`stub.echo(JSON {"text": string, "repeat"?: integer})`, "// text: what to echo"} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("system prompt lacks %q:\n%s", want, prompt)
+		}
+	}
+
+	client := &scriptedClient{replies: []string{
+		`动作：stub.echo` + "\n" + `动作输入：{"text": "hi", "repeat": 3}`,
+		`动作：stub.echo` + "\n" + `动作输入：{"repeat": "x"}`,
+		`动作：stub.fail_always` + "\n" + `动作输入：`,
+		"最终答案：完成",
+	}}
+	it, ch := agents.NewWithRegistry(client, nil, reg).Iter(nil, "问题")
+	var out strings.Builder
+	for chunk := range it {
+		out.WriteString(chunk)
+	}
+	<-ch
+	for _, want := range []string{
+		"观察：hihihi",
+		`missing required property "text"`,
+		`property "repeat" must be integer`,
+		"执行出错: boom",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output lacks %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestToolName(t *testing.T) {
+	for in, want := range map[string]string{
+		"read_file":    "read_file",
+		"read-file":    "read_file",
+		"github.issue": "github.issue",
+		"2fa/verify":   "t2fa_verify",
+		"a..b":         "a.t.b",
+	} {
+		if got := ToolName(in); got != want || !agents.ValidToolName(got) {
+			t.Errorf("ToolName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestReadEventStream(t *testing.T) {
+	for name, stream := range map[string]string{
+		"terminated":   "data: {\"jsonrpc\":\"2.0\",\"method\":\"notifications/progress\"}\n\ndata: {\"jsonrpc\":\"2.0\",\"id\":1,\"result\":{}}\n\n",
+		"unterminated": "data: {\"jsonrpc\":\"2.0\",\"id\":1,\"result\":{}}\n",
+	} {
+		m, err := readEventStream(strings.NewReader(stream), json.RawMessage("1"))
+		if err != nil || string(m.ID) != "1" {
+			t.Errorf("%s: got %+v, %v", name, m, err)
+		}
+	}
+	if _, err := readEventStream(strings.NewReader("data: {\"jsonrpc\":\"2.0\",\"id\":2,\"result\":{}}\n"), json.RawMessage("1")); err == nil {
+		t.Error("matched a response with another id")
+	}
+}
+
+// scriptedClient returns canned model replies in order.
+type scriptedClient struct {
+	replies []string
+}
+
+func (c *scriptedClient) Chat(ctx context.Context, messages []openai.Message, stop []string) (string, error) {
+	reply := c.replies[0]
+	c.replies = c.replies[1:]
+	return reply, nil
+}
+
+func (c *scriptedClient) ChatStream(ctx context.Context, messages []openai.Message, stop []string) (iter.Seq[string], error) {
+	reply, err := c.Chat(ctx, messages, stop)
+	return func(yield func(string) bool) { yield(reply) }, err
+}
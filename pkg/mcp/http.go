@@ -0,0 +1,180 @@
+package mcp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// HTTPTransport implements the streamable HTTP transport: every message is
+// POSTed to one endpoint and the response arrives either as a JSON body or
+// as a server-sent event stream.
+type HTTPTransport struct {
+	URL string
+	// Header is added to every request, e.g. for an Authorization header.
+	Header http.Header
+	Client *http.Client
+
+	mu              sync.Mutex
+	sessionID       string
+	protocolVersion string
+}
+
+// NewHTTPTransport returns a transport for the endpoint at url.
+func NewHTTPTransport(url string) *HTTPTransport {
+	return &HTTPTransport{URL: url, Header: http.Header{}, Client: http.DefaultClient}
+}
+
+func (t *HTTPTransport) setProtocolVersion(v string) {
+	t.mu.Lock()
+	t.protocolVersion = v
+	t.mu.Unlock()
+}
+
+func (t *HTTPTransport) newRequest(ctx context.Context, method string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, t.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range t.Header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	t.mu.Lock()
+	if t.sessionID != "" {
+		req.Header.Set("Mcp-Session-Id", t.sessionID)
+	}
+	if t.protocolVersion != "" {
+		req.Header.Set("MCP-Protocol-Version", t.protocolVersion)
+	}
+	t.mu.Unlock()
+	return req, nil
+}
+
+func (t *HTTPTransport) post(ctx context.Context, m *Message) (*http.Response, error) {
+	body, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	req, err := t.newRequest(ctx, http.MethodPost, body)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if id := resp.Header.Get("Mcp-Session-Id"); id != "" {
+		t.mu.Lock()
+		t.sessionID = id
+		t.mu.Unlock()
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("mcp: %s: status %d: %s", m.Method, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+// Call implements Transport.
+func (t *HTTPTransport) Call(ctx context.Context, req *Message) (*Message, error) {
+	resp, err := t.post(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/json":
+		var m Message
+		if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+			return nil, fmt.Errorf("mcp: %s: decoding response: %w", req.Method, err)
+		}
+		return &m, nil
+	case "text/event-stream":
+		return readEventStream(resp.Body, req.ID)
+	default:
+		return nil, fmt.Errorf("mcp: %s: unexpected content type %q", req.Method, mediaType)
+	}
+}
+
+// readEventStream returns the response with the given id from an SSE stream.
+// Other messages in the stream (notifications, server requests) are skipped.
+func readEventStream(r io.Reader, id json.RawMessage) (*Message, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 16<<20)
+	var data strings.Builder
+	// match reports the buffered event if it is the response.
+	match := func() *Message {
+		var m Message
+		if err := json.Unmarshal([]byte(data.String()), &m); err == nil && m.IsResponse() && bytes.Equal(m.ID, id) {
+			return &m
+		}
+		return nil
+	}
+	for sc.Scan() {
+		line := sc.Text()
+		if v, ok := strings.CutPrefix(line, "data:"); ok {
+			data.WriteString(strings.TrimPrefix(v, " "))
+			data.WriteByte('\n')
+			continue
+		}
+		if line != "" || data.Len() == 0 {
+			continue
+		}
+		if m := match(); m != nil {
+			return m, nil
+		}
+		data.Reset()
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	// The server may close the stream without the blank line ending the last event.
+	if data.Len() > 0 {
+		if m := match(); m != nil {
+			return m, nil
+		}
+	}
+	return nil, fmt.Errorf("mcp: event stream ended without a response")
+}
+
+// Notify implements Transport.
+func (t *HTTPTransport) Notify(ctx context.Context, n *Message) error {
+	resp, err := t.post(ctx, n)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Close ends the session on the server, if it issued one.
+func (t *HTTPTransport) Close() error {
+	t.mu.Lock()
+	id := t.sessionID
+	t.mu.Unlock()
+	if id == "" {
+		return nil
+	}
+	req, err := t.newRequest(context.Background(), http.MethodDelete, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := t.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
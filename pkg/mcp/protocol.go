@@ -0,0 +1,126 @@
+// Package mcp implements a Model Context Protocol client over the stdio and
+// streamable HTTP transports, and adapts the tools of an MCP server into
+// agent tools. The wire types are shared with package mcpserver.
+package mcp
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+)
+
+// ProtocolVersion is the MCP revision requested during initialization.
+const ProtocolVersion = "2025-06-18"
+
+// supportedVersions are the revisions this package can talk, newest first.
+var supportedVersions = []string{ProtocolVersion, "2025-03-26", "2024-11-05"}
+
+// SupportsVersion reports whether v is a protocol revision this package
+// can talk.
+func SupportsVersion(v string) bool { return slices.Contains(supportedVersions, v) }
+
+// Message is a JSON-RPC 2.0 request, notification or response. Requests
+// have Method and ID, notifications only Method, responses ID and either
+// Result or Error.
+type Message struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+// IsResponse reports whether m answers a request.
+func (m *Message) IsResponse() bool { return m.Method == "" && m.ID != nil }
+
+// Error is a JSON-RPC error object.
+type Error struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (e *Error) Error() string { return fmt.Sprintf("mcp: %s (code %d)", e.Message, e.Code) }
+
+// Standard JSON-RPC error codes.
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+)
+
+// Implementation identifies a client or server.
+type Implementation struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// InitializeParams are the client's initialize request parameters.
+type InitializeParams struct {
+	ProtocolVersion string         `json:"protocolVersion"`
+	Capabilities    map[string]any `json:"capabilities"`
+	ClientInfo      Implementation `json:"clientInfo"`
+}
+
+// InitializeResult is the server's reply to initialize.
+type InitializeResult struct {
+	ProtocolVersion string         `json:"protocolVersion"`
+	Capabilities    map[string]any `json:"capabilities"`
+	ServerInfo      Implementation `json:"serverInfo"`
+	Instructions    string         `json:"instructions,omitempty"`
+}
+
+// Tool describes a tool offered by a server.
+type Tool struct {
+	Name        string  `json:"name"`
+	Title       string  `json:"title,omitempty"`
+	Description string  `json:"description,omitempty"`
+	InputSchema *Schema `json:"inputSchema"`
+}
+
+// ListToolsParams are the tools/list parameters.
+type ListToolsParams struct {
+	Cursor string `json:"cursor,omitempty"`
+}
+
+// ListToolsResult is a page of tools/list results.
+type ListToolsResult struct {
+	Tools      []Tool `json:"tools"`
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// CallToolParams are the tools/call parameters.
+type CallToolParams struct {
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+}
+
+// Content is one item of a tool result. Only text is turned into
+// observations; other kinds are summarized.
+type Content struct {
+	Type     string `json:"type"` // text, image, audio, resource_link or resource
+	Text     string `json:"text,omitempty"`
+	MimeType string `json:"mimeType,omitempty"`
+	Data     string `json:"data,omitempty"` // base64, for image and audio
+	URI      string `json:"uri,omitempty"`  // for resource_link
+	// Resource is the embedded resource of a "resource" item.
+	Resource *ResourceContents `json:"resource,omitempty"`
+}
+
+// ResourceContents is an embedded resource.
+type ResourceContents struct {
+	URI      string `json:"uri"`
+	MimeType string `json:"mimeType,omitempty"`
+	Text     string `json:"text,omitempty"`
+}
+
+// CallToolResult is the result of tools/call. IsError marks a failure
+// reported by the tool itself, as opposed to a protocol error.
+type CallToolResult struct {
+	Content           []Content       `json:"content"`
+	StructuredContent json.RawMessage `json:"structuredContent,omitempty"`
+	IsError           bool            `json:"isError,omitempty"`
+}
@@ -0,0 +1,171 @@
+package mcp
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Schema is the subset of JSON Schema used by MCP tool input schemas.
+// Unknown keywords are ignored.
+type Schema struct {
+	Type        any                `json:"type,omitempty"` // a string, or a list of strings
+	Description string             `json:"description,omitempty"`
+	Properties  map[string]*Schema `json:"properties,omitempty"`
+	Required    []string           `json:"required,omitempty"`
+	Items       *Schema            `json:"items,omitempty"`
+	Enum        []any              `json:"enum,omitempty"`
+}
+
+// types returns the allowed JSON types; nil means any.
+func (s *Schema) types() []string {
+	switch t := s.Type.(type) {
+	case string:
+		return []string{t}
+	case []any:
+		var out []string
+		for _, v := range t {
+			if s, ok := v.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// propertyNames returns the property names, required ones first, each group
+// sorted, so rendered signatures are stable.
+func (s *Schema) propertyNames() []string {
+	var required, optional []string
+	for name := range s.Properties {
+		if slices.Contains(s.Required, name) {
+			required = append(required, name)
+		} else {
+			optional = append(optional, name)
+		}
+	}
+	slices.Sort(required)
+	slices.Sort(optional)
+	return append(required, optional...)
+}
+
+// Signature renders an object schema compactly for the system prompt, e.g.
+// {"query": string, "limit"?: integer}.
+func (s *Schema) Signature() string {
+	if s == nil || len(s.Properties) == 0 {
+		return "{}"
+	}
+	var parts []string
+	for _, name := range s.propertyNames() {
+		key := fmt.Sprintf("%q", name)
+		if !slices.Contains(s.Required, name) {
+			key += "?"
+		}
+		parts = append(parts, key+": "+s.Properties[name].typeName())
+	}
+	return "{" + strings.Join(parts, ", ") + "}"
+}
+
+func (s *Schema) typeName() string {
+	if s == nil {
+		return "any"
+	}
+	if len(s.Enum) > 0 {
+		var vals []string
+		for _, v := range s.Enum {
+			b, _ := json.Marshal(v)
+			vals = append(vals, string(b))
+		}
+		return strings.Join(vals, " | ")
+	}
+	types := s.types()
+	if len(types) == 0 {
+		return "any"
+	}
+	for i, t := range types {
+		switch t {
+		case "array":
+			types[i] = s.Items.typeName() + "[]"
+		case "object":
+			if len(s.Properties) > 0 {
+				types[i] = s.Signature()
+			}
+		}
+	}
+	return strings.Join(types, " | ")
+}
+
+// ParamDocs describes the properties that have a description, one per
+// line, or returns "" if none do.
+func (s *Schema) ParamDocs() string {
+	if s == nil {
+		return ""
+	}
+	var lines []string
+	for _, name := range s.propertyNames() {
+		if d := strings.TrimSpace(s.Properties[name].Description); d != "" {
+			lines = append(lines, name+": "+d)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Validate checks that args (a decoded JSON object) has the required
+// properties and that property values have the declared types. It is a
+// shallow check meant to give the model a precise error before the call is
+// forwarded; the server still validates everything.
+func (s *Schema) Validate(args map[string]any) error {
+	if s == nil {
+		return nil
+	}
+	var errs []string
+	for _, name := range s.Required {
+		if _, ok := args[name]; !ok {
+			errs = append(errs, fmt.Sprintf("missing required property %q", name))
+		}
+	}
+	for name, v := range args {
+		p, ok := s.Properties[name]
+		if !ok {
+			continue
+		}
+		if types := p.types(); len(types) > 0 && !slices.ContainsFunc(types, func(t string) bool { return hasType(v, t) }) {
+			errs = append(errs, fmt.Sprintf("property %q must be %s", name, strings.Join(types, " or ")))
+		}
+	}
+	if len(errs) > 0 {
+		slices.Sort(errs)
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// hasType reports whether a value decoded by encoding/json matches a JSON
+// Schema type name.
+func hasType(v any, typ string) bool {
+	switch typ {
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "number":
+		_, ok := v.(float64)
+		return ok
+	case "integer":
+		f, ok := v.(float64)
+		return ok && f == float64(int64(f))
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "object":
+		_, ok := v.(map[string]any)
+		return ok
+	case "array":
+		_, ok := v.([]any)
+		return ok
+	case "null":
+		return v == nil
+	}
+	return true
+}
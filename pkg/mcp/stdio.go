@@ -0,0 +1,185 @@
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// ErrClosed is returned by calls on a closed transport.
+var ErrClosed = errors.New("mcp: transport closed")
+
+// StreamTransport exchanges newline-delimited JSON-RPC messages over a
+// reader and a writer, as the stdio transport does.
+type StreamTransport struct {
+	w       io.WriteCloser
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	pending map[string]chan *Message
+	done    chan struct{}
+	err     error // why the read loop stopped
+
+	closeOnce sync.Once
+	onClose   func() error
+}
+
+// NewStreamTransport reads messages from r and writes them to w. Requests
+// the server sends to the client are answered: ping with an empty result,
+// anything else with "method not found".
+func NewStreamTransport(r io.Reader, w io.WriteCloser) *StreamTransport {
+	t := &StreamTransport{w: w, pending: map[string]chan *Message{}, done: make(chan struct{})}
+	go t.readLoop(r)
+	return t
+}
+
+// NewStdioTransport starts cmd and talks to it over its stdin and stdout.
+// The command's stderr goes to os.Stderr unless cmd.Stderr is set. Close
+// closes stdin and kills the process if it has not exited shortly after.
+func NewStdioTransport(cmd *exec.Cmd) (*StreamTransport, error) {
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if cmd.Stderr == nil {
+		cmd.Stderr = os.Stderr
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	t := NewStreamTransport(stdout, stdin)
+	t.onClose = func() error {
+		exited := make(chan error, 1)
+		go func() { exited <- cmd.Wait() }()
+		select {
+		case <-exited:
+		case <-time.After(2 * time.Second):
+			cmd.Process.Kill()
+			<-exited
+		}
+		return nil
+	}
+	return t, nil
+}
+
+func (t *StreamTransport) readLoop(r io.Reader) {
+	br := bufio.NewReader(r)
+	var err error
+	for {
+		var line []byte
+		line, err = br.ReadBytes('\n')
+		if len(line) > 0 {
+			t.dispatch(line)
+		}
+		if err != nil {
+			break
+		}
+	}
+	if err == io.EOF {
+		err = ErrClosed
+	}
+	t.mu.Lock()
+	t.err = err
+	t.mu.Unlock()
+	close(t.done)
+}
+
+func (t *StreamTransport) dispatch(line []byte) {
+	var m Message
+	if err := json.Unmarshal(line, &m); err != nil {
+		return
+	}
+	switch {
+	case m.IsResponse():
+		t.mu.Lock()
+		ch := t.pending[string(m.ID)]
+		delete(t.pending, string(m.ID))
+		t.mu.Unlock()
+		if ch != nil {
+			ch <- &m
+		}
+	case m.ID != nil: // a request from the server
+		resp := &Message{JSONRPC: "2.0", ID: m.ID}
+		if m.Method == "ping" {
+			resp.Result = json.RawMessage("{}")
+		} else {
+			resp.Error = &Error{Code: CodeMethodNotFound, Message: "method not found: " + m.Method}
+		}
+		t.write(resp)
+	}
+}
+
+func (t *StreamTransport) write(m *Message) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	_, err = t.w.Write(append(data, '\n'))
+	return err
+}
+
+// Call implements Transport. If ctx ends first, the server is told to
+// cancel the request.
+func (t *StreamTransport) Call(ctx context.Context, req *Message) (*Message, error) {
+	ch := make(chan *Message, 1)
+	t.mu.Lock()
+	if t.err != nil {
+		t.mu.Unlock()
+		return nil, t.err
+	}
+	t.pending[string(req.ID)] = ch
+	t.mu.Unlock()
+	unregister := func() {
+		t.mu.Lock()
+		delete(t.pending, string(req.ID))
+		t.mu.Unlock()
+	}
+
+	if err := t.write(req); err != nil {
+		unregister()
+		return nil, err
+	}
+	select {
+	case resp := <-ch:
+		return resp, nil
+	case <-t.done:
+		unregister()
+		return nil, t.err
+	case <-ctx.Done():
+		unregister()
+		params, _ := json.Marshal(map[string]any{"requestId": req.ID, "reason": ctx.Err().Error()})
+		t.write(&Message{JSONRPC: "2.0", Method: "notifications/cancelled", Params: params})
+		return nil, ctx.Err()
+	}
+}
+
+// Notify implements Transport.
+func (t *StreamTransport) Notify(_ context.Context, n *Message) error {
+	return t.write(n)
+}
+
+// Close implements Transport.
+func (t *StreamTransport) Close() error {
+	var err error
+	t.closeOnce.Do(func() {
+		err = t.w.Close()
+		if t.onClose != nil {
+			if cerr := t.onClose(); err == nil {
+				err = cerr
+			}
+		}
+	})
+	return err
+}
@@ -0,0 +1,143 @@
+package mcp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The test binary doubles as a stdio MCP server: when MCP_STUB_SERVER is
+// set, TestMain serves the stub tools on stdin/stdout instead of running
+// the tests.
+func TestMain(m *testing.M) {
+	if os.Getenv("MCP_STUB_SERVER") == "1" {
+		serveStubStdio()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+var stubTools = []Tool{
+	{
+		Name:        "echo",
+		Description: "Echoes the text back",
+		InputSchema: &Schema{
+			Type: "object",
+			Properties: map[string]*Schema{
+				"text":   {Type: "string", Description: "what to echo"},
+				"repeat": {Type: "integer"},
+			},
+			Required: []string{"text"},
+		},
+	},
+	{Name: "fail-always", Description: "Always fails", InputSchema: &Schema{Type: "object"}},
+	{Name: "sleep", Description: "Sleeps for a minute", InputSchema: &Schema{Type: "object"}},
+}
+
+// stubHandle answers one request; it returns nil for notifications.
+func stubHandle(m *Message) *Message {
+	if m.ID == nil {
+		return nil
+	}
+	resp := &Message{JSONRPC: "2.0", ID: m.ID}
+	result := func(v any) *Message {
+		resp.Result, _ = json.Marshal(v)
+		return resp
+	}
+	switch m.Method {
+	case "initialize":
+		return result(InitializeResult{ProtocolVersion: ProtocolVersion, ServerInfo: Implementation{Name: "stub", Version: "1"}})
+	case "tools/list":
+		// two pages, to exercise pagination
+		var p ListToolsParams
+		json.Unmarshal(m.Params, &p)
+		if p.Cursor == "" {
+			return result(ListToolsResult{Tools: stubTools[:1], NextCursor: "page2"})
+		}
+		return result(ListToolsResult{Tools: stubTools[1:]})
+	case "tools/call":
+		var p struct {
+			Name      string `json:"name"`
+			Arguments struct {
+				Text   string `json:"text"`
+				Repeat int    `json:"repeat"`
+			} `json:"arguments"`
+		}
+		json.Unmarshal(m.Params, &p)
+		switch p.Name {
+		case "echo":
+			n := max(p.Arguments.Repeat, 1)
+			return result(CallToolResult{Content: []Content{{Type: "text", Text: strings.Repeat(p.Arguments.Text, n)}}})
+		case "fail-always":
+			return result(CallToolResult{Content: []Content{{Type: "text", Text: "boom"}}, IsError: true})
+		case "sleep":
+			time.Sleep(time.Minute)
+			return result(CallToolResult{})
+		}
+		resp.Error = &Error{Code: CodeInvalidParams, Message: "unknown tool " + p.Name}
+		return resp
+	}
+	resp.Error = &Error{Code: CodeMethodNotFound, Message: "method not found"}
+	return resp
+}
+
+func serveStubStdio() {
+	dec := json.NewDecoder(os.Stdin)
+	enc := json.NewEncoder(os.Stdout)
+	for {
+		var m Message
+		if err := dec.Decode(&m); err != nil {
+			return
+		}
+		if m.Method == "tools/call" && strings.Contains(string(m.Params), `"sleep"`) {
+			go func() { stubHandle(&m) }() // never answered; the client must cancel
+			continue
+		}
+		if resp := stubHandle(&m); resp != nil {
+			enc.Encode(resp)
+		}
+	}
+}
+
+// stubHTTPHandler serves the stub tools over streamable HTTP. tools/call
+// responses are sent as an event stream preceded by a notification, other
+// responses as JSON.
+func stubHTTPHandler(t *testing.T) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		var m Message
+		if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if m.Method == "initialize" {
+			w.Header().Set("Mcp-Session-Id", "session-1")
+		} else if got := r.Header.Get("Mcp-Session-Id"); got != "session-1" {
+			t.Errorf("%s: Mcp-Session-Id = %q", m.Method, got)
+		} else if got := r.Header.Get("MCP-Protocol-Version"); got != ProtocolVersion {
+			t.Errorf("%s: MCP-Protocol-Version = %q", m.Method, got)
+		}
+
+		resp := stubHandle(&m)
+		if resp == nil {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		data, _ := json.Marshal(resp)
+		if m.Method != "tools/call" {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(data)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, "event: message\ndata: %s\n\n", `{"jsonrpc":"2.0","method":"notifications/progress","params":{}}`)
+		fmt.Fprintf(w, "event: message\ndata: %s\n\n", data)
+	})
+}
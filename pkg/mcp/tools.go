@@ -0,0 +1,119 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/eastlaugh/agent/pkg/agents"
+)
+
+var invalidNameChars = regexp.MustCompile(`[^A-Za-z0-9_]+`)
+
+// ToolName turns an MCP tool name into a valid agent tool name: characters
+// other than letters, digits and underscores become underscores within each
+// dot-separated segment, and segments that do not start with a letter get a
+// "t" prefix. For example "read-file" becomes "read_file".
+func ToolName(name string) string {
+	segments := strings.Split(name, ".")
+	for i, s := range segments {
+		s = invalidNameChars.ReplaceAllString(s, "_")
+		if s == "" || !isLetter(s[0]) {
+			s = "t" + s
+		}
+		segments[i] = s
+	}
+	return strings.Join(segments, ".")
+}
+
+func isLetter(b byte) bool { return 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z' }
+
+// Register lists the server's tools and registers each in reg as
+// namespace.<ToolName(name)>, or without a prefix if namespace is empty.
+// The model passes a JSON object matching the tool's input schema as the
+// action input; the result's text content becomes the observation. It
+// returns the registered names.
+func (c *Client) Register(ctx context.Context, reg *agents.Registry, namespace string, opts ...agents.ToolOption) ([]string, error) {
+	tools, err := c.ListTools(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, t := range tools {
+		name := ToolName(t.Name)
+		if namespace != "" {
+			name = namespace + "." + name
+		}
+		desc := t.Description
+		if desc == "" {
+			desc = t.Title
+		}
+		if docs := t.InputSchema.ParamDocs(); docs != "" {
+			desc += "\n// " + strings.ReplaceAll(docs, "\n", "\n// ")
+		}
+		toolOpts := append([]agents.ToolOption{agents.Params("(JSON " + t.InputSchema.Signature() + ")")}, opts...)
+		if err := reg.RegisterHandler(name, c.handler(t), desc, toolOpts...); err != nil {
+			return names, err
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// handler forwards an action input to the tool.
+func (c *Client) handler(t Tool) agents.Handler {
+	return func(ctx context.Context, input string) (string, error) {
+		input = strings.TrimSpace(input)
+		if input == "" {
+			input = "{}"
+		}
+		var args map[string]any
+		if err := json.Unmarshal([]byte(input), &args); err != nil {
+			return "", fmt.Errorf("action input must be a JSON object: %v", err)
+		}
+		if err := t.InputSchema.Validate(args); err != nil {
+			return "", err
+		}
+		res, err := c.CallTool(ctx, t.Name, json.RawMessage(input))
+		if err != nil {
+			return "", err
+		}
+		text := res.Text()
+		if res.IsError {
+			return "", fmt.Errorf("%s", text)
+		}
+		return text, nil
+	}
+}
+
+// Text renders the result as an observation: text items verbatim, other
+// items as a short placeholder, and the structured content if there is
+// nothing else.
+func (r *CallToolResult) Text() string {
+	var parts []string
+	for _, c := range r.Content {
+		switch c.Type {
+		case "text":
+			parts = append(parts, c.Text)
+		case "resource":
+			if c.Resource != nil && c.Resource.Text != "" {
+				parts = append(parts, c.Resource.Text)
+			} else if c.Resource != nil {
+				parts = append(parts, fmt.Sprintf("[resource %s]", c.Resource.URI))
+			}
+		case "resource_link":
+			parts = append(parts, fmt.Sprintf("[resource_link %s]", c.URI))
+		default:
+			parts = append(parts, fmt.Sprintf("[%s %s]", c.Type, c.MimeType))
+		}
+	}
+	if len(parts) == 0 && len(r.StructuredContent) > 0 {
+		return string(r.StructuredContent)
+	}
+	if len(parts) == 0 {
+		return "[no content]"
+	}
+	return strings.Join(parts, "\n")
+}
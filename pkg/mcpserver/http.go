@@ -0,0 +1,110 @@
+package mcpserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/eastlaugh/agent/pkg/mcp"
+	"github.com/google/uuid"
+)
+
+// sessions tracks the sessions issued to HTTP clients on initialize.
+type sessions struct {
+	mu  sync.Mutex
+	ids map[string]bool
+}
+
+func (ss *sessions) create() string {
+	id := uuid.NewString()
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.ids == nil {
+		ss.ids = map[string]bool{}
+	}
+	ss.ids[id] = true
+	return id
+}
+
+func (ss *sessions) valid(id string) bool {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return ss.ids[id]
+}
+
+func (ss *sessions) remove(id string) bool {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ok := ss.ids[id]
+	delete(ss.ids, id)
+	return ok
+}
+
+// ServeHTTP implements the streamable HTTP transport on a single endpoint.
+// Every message is POSTed and each request is answered with a JSON body;
+// the server never streams, so GET is refused. initialize issues a session
+// id in the Mcp-Session-Id header, which later requests must send back, and
+// DELETE ends the session. Requests from a browser page of another origin
+// are rejected to prevent DNS rebinding attacks.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if origin := r.Header.Get("Origin"); origin != "" {
+		if u, err := url.Parse(origin); err != nil || u.Host != r.Host {
+			http.Error(w, "forbidden origin", http.StatusForbidden)
+			return
+		}
+	}
+	session := r.Header.Get("Mcp-Session-Id")
+	switch r.Method {
+	case http.MethodPost:
+	case http.MethodDelete:
+		if !s.sessions.remove(session) {
+			http.Error(w, "unknown session", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		w.Header().Set("Allow", "POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var m mcp.Message
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4<<20)).Decode(&m); err != nil {
+		writeJSON(w, &mcp.Message{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &mcp.Error{Code: mcp.CodeParseError, Message: err.Error()}}, http.StatusBadRequest)
+		return
+	}
+	if m.Method == "initialize" {
+		resp := s.Handle(r.Context(), &m)
+		if resp.Error == nil {
+			w.Header().Set("Mcp-Session-Id", s.sessions.create())
+		}
+		writeJSON(w, resp, http.StatusOK)
+		return
+	}
+	switch {
+	case session == "":
+		http.Error(w, "missing Mcp-Session-Id header", http.StatusBadRequest)
+		return
+	case !s.sessions.valid(session):
+		// tells the client to initialize a new session
+		http.Error(w, "unknown session", http.StatusNotFound)
+		return
+	}
+
+	// the request context ends when the client disconnects, which is how
+	// HTTP clients cancel
+	resp := s.Handle(r.Context(), &m)
+	if resp == nil {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	writeJSON(w, resp, http.StatusOK)
+}
+
+func writeJSON(w http.ResponseWriter, m *mcp.Message, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(m)
+}
@@ -0,0 +1,199 @@
+// Package mcpserver serves the tools of an agents.Registry to MCP clients
+// over stdio or streamable HTTP.
+//
+// Tools registered as Go functions get an input schema derived from their
+// signature: Go keeps no parameter names, so the parameters become the
+// required properties arg0, arg1, ... and are decoded from JSON before the
+// function is called. Handler tools take a single string property "input".
+package mcpserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/eastlaugh/agent/pkg/agents"
+	"github.com/eastlaugh/agent/pkg/mcp"
+	"github.com/eastlaugh/agent/pkg/util"
+)
+
+// Server answers MCP requests with the tools of a registry. The registry is
+// read on every request, so tools added or removed later are picked up.
+type Server struct {
+	// Info is reported to clients during initialization.
+	Info mcp.Implementation
+	// Instructions optionally tell the client how to use the tools.
+	Instructions string
+	Logger       *slog.Logger
+
+	reg      *agents.Registry
+	sessions sessions // streamable HTTP sessions
+}
+
+// New returns a server for the tools of reg.
+func New(reg *agents.Registry) *Server {
+	return &Server{
+		Info:   mcp.Implementation{Name: "eastlaugh-agent", Version: "0.1.0"},
+		Logger: slog.Default(),
+		reg:    reg,
+	}
+}
+
+// tool is the tools/list entry. The input schema is kept as a map because
+// mcp.Schema only models the keywords the client needs.
+type tool struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	InputSchema map[string]any `json:"inputSchema"`
+}
+
+type listToolsResult struct {
+	Tools []tool `json:"tools"`
+}
+
+var handlerSchema = map[string]any{
+	"type":       "object",
+	"properties": map[string]any{"input": map[string]any{"type": "string"}},
+	"required":   []string{"input"},
+}
+
+// Handle answers one message. It returns nil for notifications and
+// responses, and when ctx was cancelled before a tool call finished: the
+// client no longer expects an answer then.
+func (s *Server) Handle(ctx context.Context, m *mcp.Message) *mcp.Message {
+	if m.ID == nil || m.IsResponse() {
+		return nil
+	}
+	resp := &mcp.Message{JSONRPC: "2.0", ID: m.ID}
+	var result any
+	var err *mcp.Error
+	switch m.Method {
+	case "initialize":
+		result, err = s.initialize(m.Params)
+	case "ping":
+		result = struct{}{}
+	case "tools/list":
+		result = s.listTools()
+	case "tools/call":
+		result, err = s.callTool(ctx, m.Params)
+		if ctx.Err() != nil {
+			return nil
+		}
+	default:
+		err = &mcp.Error{Code: mcp.CodeMethodNotFound, Message: "method not found: " + m.Method}
+	}
+	if err != nil {
+		resp.Error = err
+		return resp
+	}
+	data, merr := json.Marshal(result)
+	if merr != nil {
+		resp.Error = &mcp.Error{Code: mcp.CodeInternalError, Message: merr.Error()}
+		return resp
+	}
+	resp.Result = data
+	return resp
+}
+
+func (s *Server) initialize(params json.RawMessage) (any, *mcp.Error) {
+	var p mcp.InitializeParams
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, &mcp.Error{Code: mcp.CodeInvalidParams, Message: err.Error()}
+	}
+	// answer with the client's revision if we speak it, else with ours and
+	// let the client decide
+	version := mcp.ProtocolVersion
+	if mcp.SupportsVersion(p.ProtocolVersion) {
+		version = p.ProtocolVersion
+	}
+	return mcp.InitializeResult{
+		ProtocolVersion: version,
+		Capabilities:    map[string]any{"tools": map[string]any{}},
+		ServerInfo:      s.Info,
+		Instructions:    s.Instructions,
+	}, nil
+}
+
+func (s *Server) listTools() listToolsResult {
+	res := listToolsResult{Tools: []tool{}}
+	for _, t := range s.reg.Tools() {
+		schema := handlerSchema
+		if t.Func != nil {
+			schema = util.FuncSchema(t.Func)
+		}
+		res.Tools = append(res.Tools, tool{Name: t.Name, Description: t.Description, InputSchema: schema})
+	}
+	return res
+}
+
+// callTool runs a tool. Failures of the tool itself, including invalid
+// arguments and panics, are reported in the result with isError set so the
+// client's model can see them; only an unknown tool is a protocol error.
+func (s *Server) callTool(ctx context.Context, params json.RawMessage) (any, *mcp.Error) {
+	var p mcp.CallToolParams
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, &mcp.Error{Code: mcp.CodeInvalidParams, Message: err.Error()}
+	}
+	info, ok := s.reg.Tool(p.Name)
+	if !ok {
+		return nil, &mcp.Error{Code: mcp.CodeInvalidParams, Message: "unknown tool: " + p.Name}
+	}
+	var args map[string]json.RawMessage
+	if len(p.Arguments) > 0 {
+		if err := json.Unmarshal(p.Arguments, &args); err != nil {
+			return errorResult("arguments must be a JSON object: " + err.Error()), nil
+		}
+	}
+
+	start := time.Now()
+	type outcome struct {
+		output string
+		err    error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- outcome{err: fmt.Errorf("panic: %v", r)}
+			}
+		}()
+		output, err := invoke(ctx, info, args)
+		done <- outcome{output, err}
+	}()
+	// Go functions cannot be interrupted; on cancellation the call keeps
+	// running in the background and its result is dropped
+	var o outcome
+	select {
+	case o = <-done:
+	case <-ctx.Done():
+		o.err = ctx.Err()
+	}
+
+	logger := s.Logger.With("tool", info.Name, "duration", time.Since(start))
+	if o.err != nil {
+		logger.WarnContext(ctx, "mcp tool call failed", "error", o.err)
+		return errorResult(o.err.Error()), nil
+	}
+	logger.DebugContext(ctx, "mcp tool call")
+	return mcp.CallToolResult{Content: []mcp.Content{{Type: "text", Text: o.output}}}, nil
+}
+
+func invoke(ctx context.Context, t agents.ToolInfo, args map[string]json.RawMessage) (string, error) {
+	if t.Func != nil {
+		output, _, err := util.CallFuncJSON(t.Func, args)
+		return output, err
+	}
+	var input string
+	if raw, ok := args["input"]; ok {
+		if err := json.Unmarshal(raw, &input); err != nil {
+			return "", fmt.Errorf("argument input: %w", err)
+		}
+	}
+	return t.Handler(ctx, input)
+}
+
+func errorResult(text string) mcp.CallToolResult {
+	return mcp.CallToolResult{Content: []mcp.Content{{Type: "text", Text: text}}, IsError: true}
+}
@@ -0,0 +1,160 @@
+package mcpserver
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eastlaugh/agent/pkg/agents"
+	"github.com/eastlaugh/agent/pkg/logging"
+	"github.com/eastlaugh/agent/pkg/mcp"
+)
+
+type point struct {
+	X int `json:"x"`
+	Y int `json:"y" desc:"vertical"`
+}
+
+func testRegistry() *agents.Registry {
+	block := make(chan struct{})
+	reg := agents.NewRegistry().
+		MustRegister("text.repeat", strings.Repeat, "repeats a string", agents.Alias("repeat")).
+		MustRegister("sum", func(p point, scale ...int) int {
+			s := p.X + p.Y
+			for _, k := range scale {
+				s *= k
+			}
+			return s
+		}, "adds coordinates").
+		MustRegister("boom", func() string { panic("kaboom") }, "always panics").
+		MustRegister("block", func() string { <-block; return "" }, "never returns")
+	reg.RegisterHandler("h.upper", func(_ context.Context, input string) (string, error) {
+		return strings.ToUpper(input), nil
+	}, "upper-cases the input")
+	return reg
+}
+
+func newServer() *Server {
+	s := New(testRegistry())
+	s.Logger = logging.Discard()
+	return s
+}
+
+// dialPipe connects a client to s.ServeStdio through in-memory pipes.
+func dialPipe(t *testing.T, s *Server) *mcp.Client {
+	t.Helper()
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	go s.ServeStdio(context.Background(), sr, sw)
+	c, err := mcp.NewClient(context.Background(), mcp.NewStreamTransport(cr, cw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func call(t *testing.T, c *mcp.Client, name, args string) *mcp.CallToolResult {
+	t.Helper()
+	res, err := c.CallTool(context.Background(), name, json.RawMessage(args))
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	return res
+}
+
+func testServer(t *testing.T, c *mcp.Client) {
+	tools, err := c.ListTools(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, tool := range tools {
+		names = append(names, tool.Name)
+	}
+	if got, want := strings.Join(names, " "), "block boom h.upper sum text.repeat"; got != want {
+		t.Fatalf("tools = %s, want %s", got, want)
+	}
+	if got, want := tools[3].InputSchema.Signature(), `{"arg0": {"x": integer, "y": integer}, "arg1": integer[]}`; got != want {
+		t.Errorf("sum schema = %s, want %s", got, want)
+	}
+
+	for _, tc := range []struct {
+		name, args, want string
+		isError          bool
+	}{
+		{"text.repeat", `{"arg0": "a b ", "arg1": 2}`, "a b a b ", false},
+		{"repeat", `{"arg0": "x", "arg1": 3}`, "xxx", false},
+		{"sum", `{"arg0": {"x": 1, "y": 2}, "arg1": [2, 5]}`, "30", false},
+		{"h.upper", `{"input": "hi"}`, "HI", false},
+		{"text.repeat", `{"arg0": "x"}`, "missing argument arg1", true},
+		{"text.repeat", `{"arg0": 1, "arg1": 2}`, "argument arg0", true},
+		{"boom", `{}`, "panic: kaboom", true},
+	} {
+		res := call(t, c, tc.name, tc.args)
+		if res.IsError != tc.isError || !strings.Contains(res.Text(), tc.want) {
+			t.Errorf("%s(%s) = %q (isError %v), want %q (isError %v)", tc.name, tc.args, res.Text(), res.IsError, tc.want, tc.isError)
+		}
+	}
+	if _, err := c.CallTool(context.Background(), "missing", nil); err == nil || !strings.Contains(err.Error(), "unknown tool") {
+		t.Errorf("missing tool error = %v", err)
+	}
+}
+
+func TestStdio(t *testing.T) {
+	testServer(t, dialPipe(t, newServer()))
+}
+
+func TestHTTP(t *testing.T) {
+	srv := httptest.NewServer(newServer())
+	defer srv.Close()
+	c, err := mcp.DialHTTP(context.Background(), srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testServer(t, c)
+	c.Close()
+
+	// the session is gone after Close
+	req, _ := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"ping"}`))
+	req.Header.Set("Mcp-Session-Id", "unknown")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("status = %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestStdioCancel(t *testing.T) {
+	c := dialPipe(t, newServer())
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.CallTool(ctx, "block", nil); err != context.DeadlineExceeded {
+		t.Fatalf("err = %v", err)
+	}
+	// the server keeps serving other requests
+	if res := call(t, c, "repeat", `{"arg0": "x", "arg1": 2}`); res.Text() != "xx" {
+		t.Errorf("repeat = %q", res.Text())
+	}
+}
+
+// The tools of one agent can be served and imported by another.
+func TestRoundTrip(t *testing.T) {
+	c := dialPipe(t, newServer())
+	reg := agents.NewRegistry()
+	if _, err := c.Register(context.Background(), reg, "remote"); err != nil {
+		t.Fatal(err)
+	}
+	prompt := agents.NewWithRegistry(nil, nil, reg).SystemPrompt()
+	if want := `remote.text.repeat(JSON {"arg0": string, "arg1": integer})`; !strings.Contains(prompt, want) {
+		t.Errorf("system prompt lacks %q:\n%s", want, prompt)
+	}
+}
@@ -0,0 +1,112 @@
+package mcpserver
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+
+	"github.com/eastlaugh/agent/pkg/mcp"
+)
+
+// ServeStdio reads newline-delimited JSON-RPC messages from r and writes
+// the responses to w, as a server launched by an MCP client does on its
+// stdin and stdout. Requests are handled concurrently; a
+// notifications/cancelled message cancels the request it names. It returns
+// when r reaches EOF, after pending requests have finished, or when ctx is
+// done.
+func (s *Server) ServeStdio(ctx context.Context, r io.Reader, w io.Writer) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		writeMu sync.Mutex
+		mu      sync.Mutex
+		pending = map[string]context.CancelFunc{}
+		wg      sync.WaitGroup
+	)
+	write := func(m *mcp.Message) {
+		data, err := json.Marshal(m)
+		if err != nil {
+			return
+		}
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		w.Write(append(data, '\n'))
+	}
+
+	lines := make(chan []byte)
+	readErr := make(chan error, 1)
+	go func() {
+		br := bufio.NewReader(r)
+		for {
+			line, err := br.ReadBytes('\n')
+			if len(line) > 0 {
+				select {
+				case lines <- line:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if err != nil {
+				readErr <- err
+				return
+			}
+		}
+	}()
+
+	for {
+		var line []byte
+		select {
+		case line = <-lines:
+		case err := <-readErr:
+			wg.Wait()
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		var m mcp.Message
+		if err := json.Unmarshal(line, &m); err != nil {
+			write(&mcp.Message{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &mcp.Error{Code: mcp.CodeParseError, Message: err.Error()}})
+			continue
+		}
+		if m.Method == "notifications/cancelled" {
+			var p struct {
+				RequestID json.RawMessage `json:"requestId"`
+			}
+			json.Unmarshal(m.Params, &p)
+			mu.Lock()
+			if cancel := pending[string(p.RequestID)]; cancel != nil {
+				cancel()
+			}
+			mu.Unlock()
+			continue
+		}
+		if m.ID == nil || m.IsResponse() {
+			continue
+		}
+
+		reqCtx, reqCancel := context.WithCancel(ctx)
+		id := string(m.ID)
+		mu.Lock()
+		pending[id] = reqCancel
+		mu.Unlock()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp := s.Handle(reqCtx, &m)
+			mu.Lock()
+			delete(pending, id)
+			mu.Unlock()
+			reqCancel()
+			if resp != nil {
+				write(resp)
+			}
+		}()
+	}
+}
@@ -0,0 +1,305 @@
+// Package metrics implements the small subset of Prometheus metric types the
+// agent server needs (counters, gauges and histograms with labels) and
+// renders them in the Prometheus text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefBuckets are the default histogram buckets, in seconds.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}
+
+type collector interface {
+	write(w io.Writer)
+}
+
+// Registry holds metrics and exposes them over HTTP.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []collector
+	names   map[string]bool
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{names: map[string]bool{}}
+}
+
+func (r *Registry) register(name string, c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[name] {
+		panic("metrics: duplicate metric " + name)
+	}
+	r.names[name] = true
+	r.metrics = append(r.metrics, c)
+}
+
+// Write writes every registered metric in the text exposition format.
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	metrics := slices.Clone(r.metrics)
+	r.mu.Unlock()
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+// ServeHTTP serves the metrics, so a Registry can be mounted at /metrics.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.Write(w)
+}
+
+// family is the label bookkeeping shared by all vector types.
+type family[T any] struct {
+	name, help, typ string
+	labels          []string
+
+	mu     sync.Mutex
+	series map[string]*T
+	keys   map[string][]string // series key -> label values
+	newT   func() *T
+}
+
+func (f *family[T]) with(values ...string) *T {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.series[key]
+	if !ok {
+		s = f.newT()
+		f.series[key] = s
+		f.keys[key] = slices.Clone(values)
+	}
+	return s
+}
+
+// each calls fn for every series in a stable order.
+func (f *family[T]) each(fn func(labels string, s *T)) {
+	f.mu.Lock()
+	keys := make([]string, 0, len(f.series))
+	for k := range f.series {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	series := make([]*T, len(keys))
+	values := make([][]string, len(keys))
+	for i, k := range keys {
+		series[i], values[i] = f.series[k], f.keys[k]
+	}
+	f.mu.Unlock()
+
+	for i := range keys {
+		fn(formatLabels(f.labels, values[i]), series[i])
+	}
+}
+
+func (f *family[T]) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, escapeHelp(f.help), f.name, f.typ)
+}
+
+func newFamily[T any](name, help, typ string, labels []string, newT func() *T) *family[T] {
+	return &family[T]{
+		name: name, help: help, typ: typ, labels: labels,
+		series: map[string]*T{}, keys: map[string][]string{}, newT: newT,
+	}
+}
+
+// value is a float64 guarded by a mutex, used by counters and gauges.
+type value struct {
+	mu sync.Mutex
+	v  float64
+}
+
+func (v *value) add(d float64) {
+	v.mu.Lock()
+	v.v += d
+	v.mu.Unlock()
+}
+
+func (v *value) get() float64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.v
+}
+
+// Counter is a monotonically increasing value.
+type Counter struct{ value }
+
+// Inc adds one.
+func (c *Counter) Inc() { c.add(1) }
+
+// Add adds d, which must not be negative.
+func (c *Counter) Add(d float64) {
+	if d < 0 {
+		panic("metrics: counter cannot decrease")
+	}
+	c.add(d)
+}
+
+// Gauge is a value that can go up and down.
+type Gauge struct{ value }
+
+// Inc adds one.
+func (g *Gauge) Inc() { g.add(1) }
+
+// Dec subtracts one.
+func (g *Gauge) Dec() { g.add(-1) }
+
+// Add adds d.
+func (g *Gauge) Add(d float64) { g.add(d) }
+
+// Histogram samples observations into cumulative buckets.
+type Histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+// Observe records v.
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, b := range h.buckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// CounterVec is a set of counters partitioned by label values.
+type CounterVec struct{ f *family[Counter] }
+
+// NewCounterVec registers a counter family on r.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	v := &CounterVec{newFamily(name, help, "counter", labels, func() *Counter { return &Counter{} })}
+	r.register(name, v)
+	return v
+}
+
+// With returns the counter for the given label values, in declaration order.
+func (v *CounterVec) With(values ...string) *Counter { return v.f.with(values...) }
+
+func (v *CounterVec) write(w io.Writer) {
+	v.f.header(w)
+	v.f.each(func(labels string, c *Counter) {
+		fmt.Fprintf(w, "%s%s %s\n", v.f.name, labels, formatFloat(c.get()))
+	})
+}
+
+// GaugeVec is a set of gauges partitioned by label values.
+type GaugeVec struct{ f *family[Gauge] }
+
+// NewGaugeVec registers a gauge family on r.
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	v := &GaugeVec{newFamily(name, help, "gauge", labels, func() *Gauge { return &Gauge{} })}
+	r.register(name, v)
+	return v
+}
+
+// With returns the gauge for the given label values, in declaration order.
+func (v *GaugeVec) With(values ...string) *Gauge { return v.f.with(values...) }
+
+func (v *GaugeVec) write(w io.Writer) {
+	v.f.header(w)
+	v.f.each(func(labels string, g *Gauge) {
+		fmt.Fprintf(w, "%s%s %s\n", v.f.name, labels, formatFloat(g.get()))
+	})
+}
+
+// HistogramVec is a set of histograms partitioned by label values.
+type HistogramVec struct{ f *family[Histogram] }
+
+// NewHistogramVec registers a histogram family on r. buckets must be sorted;
+// DefBuckets is used when it is nil.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+	if !slices.IsSorted(buckets) {
+		panic("metrics: histogram buckets must be sorted")
+	}
+	v := &HistogramVec{newFamily(name, help, "histogram", labels, func() *Histogram {
+		return &Histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+	})}
+	r.register(name, v)
+	return v
+}
+
+// With returns the histogram for the given label values, in declaration order.
+func (v *HistogramVec) With(values ...string) *Histogram { return v.f.with(values...) }
+
+func (v *HistogramVec) write(w io.Writer) {
+	v.f.header(w)
+	v.f.each(func(labels string, h *Histogram) {
+		h.mu.Lock()
+		counts, sum, count := slices.Clone(h.counts), h.sum, h.count
+		h.mu.Unlock()
+
+		for i, b := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", v.f.name, withLabel(labels, "le", formatFloat(b)), counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", v.f.name, withLabel(labels, "le", "+Inf"), count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", v.f.name, labels, formatFloat(sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", v.f.name, labels, count)
+	})
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, n := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=\"%s\"", n, escapeLabel(values[i]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func withLabel(labels, name, value string) string {
+	pair := fmt.Sprintf("%s=\"%s\"", name, value)
+	if labels == "" {
+		return "{" + pair + "}"
+	}
+	return labels[:len(labels)-1] + "," + pair + "}"
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+
+func escapeHelp(s string) string { return helpEscaper.Replace(s) }
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,42 @@
+package metrics_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/eastlaugh/agent/pkg/metrics"
+)
+
+func TestExposition(t *testing.T) {
+	r := metrics.NewRegistry()
+	calls := r.NewCounterVec("tool_calls_total", "Tool calls.", "tool")
+	active := r.NewGaugeVec("active_streams", "Active streams.")
+	latency := r.NewHistogramVec("latency_seconds", "Latency.", []float64{0.1, 1}, "model")
+
+	calls.With(`a"b`).Inc()
+	calls.With("web.get").Add(2)
+	active.With().Inc()
+	latency.With("gpt").Observe(0.5)
+	latency.With("gpt").Observe(3)
+
+	var b strings.Builder
+	r.Write(&b)
+	want := `# HELP tool_calls_total Tool calls.
+# TYPE tool_calls_total counter
+tool_calls_total{tool="a\"b"} 1
+tool_calls_total{tool="web.get"} 2
+# HELP active_streams Active streams.
+# TYPE active_streams gauge
+active_streams 1
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{model="gpt",le="0.1"} 0
+latency_seconds_bucket{model="gpt",le="1"} 1
+latency_seconds_bucket{model="gpt",le="+Inf"} 2
+latency_seconds_sum{model="gpt"} 3.5
+latency_seconds_count{model="gpt"} 2
+`
+	if b.String() != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
@@ -3,12 +3,17 @@ package openai
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"iter"
+	"log/slog"
 	"net/http"
+	"strings"
 	"time"
+
+	"github.com/eastlaugh/agent/pkg/trace"
 )
 
 // Message represents a chat message.
@@ -19,18 +24,33 @@ type Message struct {
 
 // CompletionRequest represents the payload sent to the OpenAI API.
 type CompletionRequest struct {
-	Model       string    `json:"model"`
-	Messages    []Message `json:"messages"`
-	Temperature float64   `json:"temperature"`
-	Stop        []string  `json:"stop,omitempty"` // Important for ReAct to stop at "Observation:"
-	Stream      bool      `json:"stream,omitempty"`
+	Model         string         `json:"model"`
+	Messages      []Message      `json:"messages"`
+	Temperature   float64        `json:"temperature"`
+	Stop          []string       `json:"stop,omitempty"` // Important for ReAct to stop at "Observation:"
+	Stream        bool           `json:"stream,omitempty"`
+	StreamOptions *StreamOptions `json:"stream_options,omitempty"`
+}
+
+// StreamOptions configures streaming responses.
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// Usage reports the tokens consumed by a request.
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
 }
 
 // CompletionResponse represents the response from the OpenAI API.
 type CompletionResponse struct {
 	Choices []struct {
-		Message Message `json:"message"`
+		Message      Message `json:"message"`
+		FinishReason string  `json:"finish_reason"`
 	} `json:"choices"`
+	Usage *Usage `json:"usage,omitempty"`
 }
 
 // StreamChunk represents a chunk in the streaming response.
@@ -39,7 +59,9 @@ type StreamChunk struct {
 		Delta struct {
 			Content string `json:"content"`
 		} `json:"delta"`
+		FinishReason string `json:"finish_reason"`
 	} `json:"choices"`
+	Usage *Usage `json:"usage,omitempty"` // Only in the last chunk, when requested
 }
 
 // Client is a minimal OpenAI-compatible API client.
@@ -48,6 +70,61 @@ type Client struct {
 	APIKey     string
 	Model      string
 	HTTPClient *http.Client
+	// StreamUsage asks for token usage in streaming responses via
+	// stream_options. Disable it for providers that reject the field.
+	StreamUsage bool
+	// Metrics, if set, is told about every request.
+	Metrics Metrics
+	// Logger, if set, logs every request at debug level and failures at
+	// warn level. Attributes attached to ctx by the caller are included.
+	Logger *slog.Logger
+}
+
+// Metrics receives measurements of the requests made by a Client.
+// Implementations must be safe for concurrent use.
+type Metrics interface {
+	// RequestDone is called when a request finishes; for streams, when the
+	// stream ends. err is nil on success.
+	RequestDone(model string, stream bool, latency time.Duration, err error)
+	// FirstToken is called when the first content chunk of a stream arrives.
+	FirstToken(model string, latency time.Duration)
+}
+
+func (c *Client) startSpan(ctx context.Context, name string, messages []Message, stop []string) (context.Context, *trace.Span) {
+	return trace.Start(ctx, name,
+		trace.String("llm.model", c.Model),
+		trace.Int("llm.messages", len(messages)),
+		trace.String("llm.stop", strings.Join(stop, ",")),
+	)
+}
+
+func usageAttrs(u Usage, finishReason string) []trace.Attr {
+	return []trace.Attr{
+		trace.String("llm.finish_reason", finishReason),
+		trace.Int("llm.usage.prompt_tokens", u.PromptTokens),
+		trace.Int("llm.usage.completion_tokens", u.CompletionTokens),
+		trace.Int("llm.usage.total_tokens", u.TotalTokens),
+	}
+}
+
+func (c *Client) requestDone(ctx context.Context, stream bool, start time.Time, err error) {
+	latency := time.Since(start)
+	if c.Metrics != nil {
+		c.Metrics.RequestDone(c.Model, stream, latency, err)
+	}
+	if c.Logger == nil {
+		return
+	}
+	attrs := []slog.Attr{
+		slog.String("model", c.Model),
+		slog.Bool("stream", stream),
+		slog.Duration("latency", latency),
+	}
+	if err != nil {
+		c.Logger.LogAttrs(ctx, slog.LevelWarn, "llm request failed", append(attrs, slog.Any("error", err))...)
+		return
+	}
+	c.Logger.LogAttrs(ctx, slog.LevelDebug, "llm request", attrs...)
 }
 
 // NewClient creates a new LLM client.
@@ -62,11 +139,20 @@ func NewClient(baseURL, apiKey, model string) *Client {
 		HTTPClient: &http.Client{
 			Timeout: 60 * time.Second,
 		},
+		StreamUsage: true,
 	}
 }
 
-// Chat sends a chat completion request.
-func (c *Client) Chat(messages []Message, stop []string) (string, error) {
+// Chat sends a chat completion request. The request is aborted when ctx is done.
+func (c *Client) Chat(ctx context.Context, messages []Message, stop []string) (_ string, err error) {
+	start := time.Now()
+	ctx, span := c.startSpan(ctx, "llm.chat", messages, stop)
+	defer func() {
+		c.requestDone(ctx, false, start, err)
+		span.RecordError(err)
+		span.End()
+	}()
+
 	reqBody := CompletionRequest{
 		Model:       c.Model,
 		Messages:    messages,
@@ -79,7 +165,7 @@ func (c *Client) Chat(messages []Message, stop []string) (string, error) {
 		return "", err
 	}
 
-	req, err := http.NewRequest("POST", c.BaseURL+"/chat/completions", bytes.NewBuffer(jsonBody))
+	req, err := http.NewRequestWithContext(ctx, "POST", c.BaseURL+"/chat/completions", bytes.NewBuffer(jsonBody))
 	if err != nil {
 		return "", err
 	}
@@ -107,12 +193,27 @@ func (c *Client) Chat(messages []Message, stop []string) (string, error) {
 		return "", fmt.Errorf("no choices in response")
 	}
 
-	return completionResp.Choices[0].Message.Content, nil
+	content := completionResp.Choices[0].Message.Content
+	usage := recordUsage(ctx, completionResp.Usage, messages, content)
+	span.SetAttrs(usageAttrs(usage, completionResp.Choices[0].FinishReason)...)
+	return content, nil
 }
 
 // ChatStream returns an iterator over streaming chat completion chunks.
-// Returns error if the streaming request fails.
-func (c *Client) ChatStream(messages []Message, stop []string) (iter.Seq[string], error) {
+// Returns error if the streaming request fails. Cancelling ctx closes the
+// underlying connection and ends the iterator early.
+func (c *Client) ChatStream(ctx context.Context, messages []Message, stop []string) (_ iter.Seq[string], err error) {
+	start := time.Now()
+	ctx, span := c.startSpan(ctx, "llm.chat_stream", messages, stop)
+	defer func() {
+		// On success the iterator reports once the stream ends.
+		if err != nil {
+			c.requestDone(ctx, true, start, err)
+			span.RecordError(err)
+			span.End()
+		}
+	}()
+
 	reqBody := CompletionRequest{
 		Model:       c.Model,
 		Messages:    messages,
@@ -120,13 +221,16 @@ func (c *Client) ChatStream(messages []Message, stop []string) (iter.Seq[string]
 		Stop:        stop,
 		Stream:      true,
 	}
+	if c.StreamUsage {
+		reqBody.StreamOptions = &StreamOptions{IncludeUsage: true}
+	}
 
 	jsonBody, err := json.Marshal(reqBody)
 	if err != nil {
 		return nil, err
 	}
 
-	req, err := http.NewRequest("POST", c.BaseURL+"/chat/completions", bytes.NewBuffer(jsonBody))
+	req, err := http.NewRequestWithContext(ctx, "POST", c.BaseURL+"/chat/completions", bytes.NewBuffer(jsonBody))
 	if err != nil {
 		return nil, err
 	}
@@ -147,7 +251,27 @@ func (c *Client) ChatStream(messages []Message, stop []string) (iter.Seq[string]
 
 	return func(yield func(string) bool) {
 		defer resp.Body.Close()
+		var usage *Usage
+		var output strings.Builder
+		var finishReason string
+		// complete is set by [DONE] or a finish reason; stopped when the consumer ends the stream early
+		var complete, stopped bool
 		scanner := bufio.NewScanner(resp.Body)
+		defer func() {
+			u := recordUsage(ctx, usage, messages, output.String())
+			err := scanner.Err()
+			if err == nil && !complete && !stopped {
+				err = ErrIncompleteStream
+			}
+			if ctx.Err() != nil {
+				err = ctx.Err()
+			}
+			reportStream(ctx, err)
+			c.requestDone(ctx, true, start, err)
+			span.SetAttrs(usageAttrs(u, finishReason)...)
+			span.RecordError(err)
+			span.End()
+		}()
 
 		for scanner.Scan() {
 			line := scanner.Text()
@@ -161,6 +285,7 @@ func (c *Client) ChatStream(messages []Message, stop []string) (iter.Seq[string]
 			}
 
 			if line == "[DONE]" {
+				complete = true
 				break
 			}
 
@@ -169,8 +294,20 @@ func (c *Client) ChatStream(messages []Message, stop []string) (iter.Seq[string]
 				continue
 			}
 
+			if chunk.Usage != nil {
+				usage = chunk.Usage
+			}
+			if len(chunk.Choices) > 0 && chunk.Choices[0].FinishReason != "" {
+				finishReason = chunk.Choices[0].FinishReason
+				complete = true
+			}
 			if len(chunk.Choices) > 0 && chunk.Choices[0].Delta.Content != "" {
+				if output.Len() == 0 && c.Metrics != nil {
+					c.Metrics.FirstToken(c.Model, time.Since(start))
+				}
+				output.WriteString(chunk.Choices[0].Delta.Content)
 				if !yield(chunk.Choices[0].Delta.Content) {
+					stopped = true
 					return
 				}
 			}
@@ -0,0 +1,60 @@
+package openai
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrIncompleteStream is reported when a stream ends before the provider sent
+// [DONE] or a finish reason, e.g. because the connection broke or
+// HTTPClient.Timeout expired mid-stream.
+var ErrIncompleteStream = errors.New("openai: stream ended before the response was complete")
+
+// StreamResult records how the streams made with a context returned by
+// WithStreamResult ended. The iterator of ChatStream cannot return an error,
+// so callers that must tell a complete reply from a truncated one, such as
+// caches, read it here after the iterator finishes. It is safe for
+// concurrent use.
+type StreamResult struct {
+	mu  sync.Mutex
+	err error
+}
+
+// Err returns the first error a stream ended with, or nil if every stream
+// ended cleanly or was stopped by its consumer.
+func (r *StreamResult) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+func (r *StreamResult) report(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+type streamResultKey struct{}
+
+// WithStreamResult returns a context that makes ChatStream report how its
+// stream ended to r. Like usage counters, results stack along the context
+// chain.
+func WithStreamResult(ctx context.Context, r *StreamResult) context.Context {
+	parents, _ := ctx.Value(streamResultKey{}).([]*StreamResult)
+	results := append(parents[:len(parents):len(parents)], r)
+	return context.WithValue(ctx, streamResultKey{}, results)
+}
+
+// reportStream reports a failed stream to the results in ctx.
+func reportStream(ctx context.Context, err error) {
+	if err == nil {
+		return
+	}
+	results, _ := ctx.Value(streamResultKey{}).([]*StreamResult)
+	for _, r := range results {
+		r.report(err)
+	}
+}
@@ -0,0 +1,65 @@
+package openai
+
+import (
+	"context"
+	"sync"
+)
+
+// UsageCounter accumulates the token usage of every request made with a
+// context returned by WithUsageCounter. It is safe for concurrent use.
+type UsageCounter struct {
+	mu    sync.Mutex
+	usage Usage
+}
+
+// Add adds u to the counter.
+func (c *UsageCounter) Add(u Usage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.usage.PromptTokens += u.PromptTokens
+	c.usage.CompletionTokens += u.CompletionTokens
+	c.usage.TotalTokens += u.TotalTokens
+}
+
+// Usage returns the accumulated usage.
+func (c *UsageCounter) Usage() Usage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.usage
+}
+
+type usageKey struct{}
+
+// WithUsageCounter returns a context that makes the client report the usage
+// of requests made with it to c. Counters stack: a request is reported to
+// every counter attached to its context chain.
+func WithUsageCounter(ctx context.Context, c *UsageCounter) context.Context {
+	parents, _ := ctx.Value(usageKey{}).([]*UsageCounter)
+	counters := append(parents[:len(parents):len(parents)], c)
+	return context.WithValue(ctx, usageKey{}, counters)
+}
+
+// recordUsage reports usage to the counters in ctx and returns it. When the
+// provider did not return usage it is estimated from the text length.
+func recordUsage(ctx context.Context, usage *Usage, messages []Message, output string) Usage {
+	u := estimateUsage(messages, output)
+	if usage != nil {
+		u = *usage
+	}
+	counters, _ := ctx.Value(usageKey{}).([]*UsageCounter)
+	for _, c := range counters {
+		c.Add(u)
+	}
+	return u
+}
+
+// estimateUsage roughly approximates token counts as one token per four
+// bytes, which is close for English and slightly high for CJK text.
+func estimateUsage(messages []Message, output string) Usage {
+	var prompt int
+	for _, m := range messages {
+		prompt += (len(m.Content)+3)/4 + 4
+	}
+	completion := (len(output) + 3) / 4
+	return Usage{PromptTokens: prompt, CompletionTokens: completion, TotalTokens: prompt + completion}
+}
@@ -0,0 +1,17 @@
+package tools
+
+import (
+	"math/rand/v2"
+	"time"
+
+	"github.com/eastlaugh/agent/pkg/agents"
+)
+
+// Builtin 返回内置工具的注册表，名称即模型看到的工具名；每次调用返回新的注册表，调用方可以自由增删
+func Builtin() *agents.Registry {
+	return agents.NewRegistry().
+		MustRegister("random.int", rand.IntN, "返回 [0, n) 之间的随机整数").
+		MustRegister("time.now", func(layout string) string { return time.Now().Format(layout) }, "按 Go 的时间格式返回当前时间").
+		MustRegister("web.search", SearchInternet, "在互联网上搜索信息").
+		MustRegister("web.get", HttpGet, "发送 HTTP GET 请求")
+}
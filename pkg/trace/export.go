@@ -0,0 +1,256 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// writerExporter prints one human-readable line per span.
+type writerExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterExporter returns an exporter that prints each finished span as a
+// single line to w (e.g. os.Stdout), which is handy offline and in tests.
+func NewWriterExporter(w io.Writer) Exporter {
+	return &writerExporter{w: w}
+}
+
+func (e *writerExporter) Export(s SpanData) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "[trace] %s %s", s.TraceID, s.SpanID)
+	if !s.ParentID.IsZero() {
+		fmt.Fprintf(&b, " parent=%s", s.ParentID)
+	}
+	fmt.Fprintf(&b, " %s %s", s.Name, s.End.Sub(s.Start).Round(time.Microsecond))
+	for _, a := range s.Attrs {
+		fmt.Fprintf(&b, " %s=%q", a.Key, fmt.Sprint(attrValue(a.Value)))
+	}
+	if s.Status == StatusError {
+		fmt.Fprintf(&b, " error=%q", s.StatusMsg)
+	}
+	b.WriteByte('\n')
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	io.WriteString(e.w, b.String())
+}
+
+func (e *writerExporter) Shutdown(context.Context) error { return nil }
+
+// OTLPExporter batches spans and emits them as OTLP/JSON
+// ExportTraceServiceRequest documents.
+type OTLPExporter struct {
+	service string
+	send    func(ctx context.Context, body []byte) error
+
+	mu      sync.Mutex
+	batch   []SpanData
+	maxSize int
+	stop    chan struct{}
+	done    chan struct{}
+	// OnError is called when a batch cannot be sent; errors are dropped if nil.
+	OnError func(error)
+}
+
+func newOTLPExporter(service string, interval time.Duration, send func(context.Context, []byte) error) *OTLPExporter {
+	e := &OTLPExporter{
+		service: service,
+		send:    send,
+		maxSize: 256,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go e.loop(interval)
+	return e
+}
+
+// NewOTLPFileExporter writes one OTLP/JSON document per line to w, in the
+// format accepted by the OpenTelemetry Collector's file receiver.
+func NewOTLPFileExporter(w io.Writer, service string) *OTLPExporter {
+	var mu sync.Mutex
+	return newOTLPExporter(service, time.Second, func(_ context.Context, body []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+		_, err := w.Write(append(body, '\n'))
+		return err
+	})
+}
+
+// NewOTLPHTTPExporter posts batches to an OTLP/HTTP endpoint such as
+// http://localhost:4318/v1/traces using the JSON encoding.
+func NewOTLPHTTPExporter(endpoint, service string) *OTLPExporter {
+	client := &http.Client{Timeout: 10 * time.Second}
+	return newOTLPExporter(service, 5*time.Second, func(ctx context.Context, body []byte) error {
+		req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+			return fmt.Errorf("otlp: status %d: %s", resp.StatusCode, msg)
+		}
+		return nil
+	})
+}
+
+func (e *OTLPExporter) Export(s SpanData) {
+	e.mu.Lock()
+	e.batch = append(e.batch, s)
+	full := len(e.batch) >= e.maxSize
+	e.mu.Unlock()
+	if full {
+		e.flush(context.Background())
+	}
+}
+
+func (e *OTLPExporter) loop(interval time.Duration) {
+	defer close(e.done)
+	tk := time.NewTicker(interval)
+	defer tk.Stop()
+	for {
+		select {
+		case <-e.stop:
+			return
+		case <-tk.C:
+			e.flush(context.Background())
+		}
+	}
+}
+
+func (e *OTLPExporter) flush(ctx context.Context) error {
+	e.mu.Lock()
+	batch := e.batch
+	e.batch = nil
+	e.mu.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+	body, err := json.Marshal(otlpRequest(e.service, batch))
+	if err == nil {
+		err = e.send(ctx, body)
+	}
+	if err != nil && e.OnError != nil {
+		e.OnError(err)
+	}
+	return err
+}
+
+// Shutdown stops the background flusher and sends the remaining spans.
+func (e *OTLPExporter) Shutdown(ctx context.Context) error {
+	select {
+	case <-e.stop:
+	default:
+		close(e.stop)
+	}
+	<-e.done
+	return e.flush(ctx)
+}
+
+// OTLP/JSON wire types, see opentelemetry-proto's JSON mapping: ids are hex,
+// 64-bit integers are strings and enums are numbers.
+type (
+	otlpKeyValue struct {
+		Key   string         `json:"key"`
+		Value map[string]any `json:"value"`
+	}
+	otlpSpan struct {
+		TraceID           string         `json:"traceId"`
+		SpanID            string         `json:"spanId"`
+		ParentSpanID      string         `json:"parentSpanId,omitempty"`
+		Name              string         `json:"name"`
+		Kind              int            `json:"kind"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+		Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+		Status            map[string]any `json:"status,omitempty"`
+	}
+)
+
+func otlpRequest(service string, spans []SpanData) map[string]any {
+	out := make([]otlpSpan, len(spans))
+	for i, s := range spans {
+		o := otlpSpan{
+			TraceID:           s.TraceID.String(),
+			SpanID:            s.SpanID.String(),
+			Name:              s.Name,
+			Kind:              1, // SPAN_KIND_INTERNAL
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+		}
+		if !s.ParentID.IsZero() {
+			o.ParentSpanID = s.ParentID.String()
+		}
+		for _, a := range s.Attrs {
+			o.Attributes = append(o.Attributes, otlpKeyValue{Key: a.Key, Value: otlpValue(a.Value)})
+		}
+		switch s.Status {
+		case StatusOK:
+			o.Status = map[string]any{"code": 1}
+		case StatusError:
+			o.Status = map[string]any{"code": 2, "message": s.StatusMsg}
+		}
+		out[i] = o
+	}
+	return map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{
+				"attributes": []otlpKeyValue{{Key: "service.name", Value: otlpValue(service)}},
+			},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "github.com/eastlaugh/agent/pkg/trace"},
+				"spans": out,
+			}},
+		}},
+	}
+}
+
+func otlpValue(v any) map[string]any {
+	switch v := attrValue(v).(type) {
+	case string:
+		return map[string]any{"stringValue": v}
+	case bool:
+		return map[string]any{"boolValue": v}
+	case int64:
+		return map[string]any{"intValue": strconv.FormatInt(v, 10)}
+	case float64:
+		return map[string]any{"doubleValue": v}
+	default:
+		return map[string]any{"stringValue": formatValue(v)}
+	}
+}
+
+// attrValue normalizes attribute values to string, bool, int64 or float64.
+func attrValue(v any) any {
+	switch v := v.(type) {
+	case string, bool, int64, float64:
+		return v
+	case int:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case uint32:
+		return int64(v)
+	case float32:
+		return float64(v)
+	case time.Duration:
+		return v.String()
+	default:
+		return formatValue(v)
+	}
+}
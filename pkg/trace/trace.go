@@ -0,0 +1,219 @@
+// Package trace is a small OpenTelemetry-style tracing abstraction. Spans
+// form a tree through context.Context; finished spans are handed to an
+// Exporter. A nil *Span is valid and turns every method into a no-op, so
+// instrumented code does not need to check whether tracing is enabled.
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// TraceID identifies a whole trace, e.g. one agent run with its sub-agents.
+type TraceID [16]byte
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+
+// SpanID identifies a span within a trace.
+type SpanID [8]byte
+
+func (id SpanID) String() string { return hex.EncodeToString(id[:]) }
+
+// IsZero reports whether the id is unset, i.e. the span is a root.
+func (id SpanID) IsZero() bool { return id == SpanID{} }
+
+// Attr is a span attribute. Value is a string, bool, integer or float;
+// anything else is exported using fmt.Sprint.
+type Attr struct {
+	Key   string
+	Value any
+}
+
+// String, Int, Bool and Float build attributes.
+func String(key, v string) Attr        { return Attr{key, v} }
+func Int(key string, v int) Attr       { return Attr{key, v} }
+func Bool(key string, v bool) Attr     { return Attr{key, v} }
+func Float(key string, v float64) Attr { return Attr{key, v} }
+
+// Status of a finished span.
+type Status int
+
+const (
+	StatusUnset Status = iota
+	StatusOK
+	StatusError
+)
+
+// SpanData is the immutable record of a finished span passed to exporters.
+type SpanData struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	ParentID   SpanID
+	Name       string
+	Start, End time.Time
+	Attrs      []Attr
+	Status     Status
+	StatusMsg  string
+}
+
+// Exporter receives finished spans. Implementations must be safe for
+// concurrent use.
+type Exporter interface {
+	Export(span SpanData)
+	// Shutdown flushes buffered spans.
+	Shutdown(ctx context.Context) error
+}
+
+// Tracer starts root spans and sends finished spans to its exporter.
+type Tracer struct {
+	exporter Exporter
+}
+
+// NewTracer returns a tracer exporting to exp.
+func NewTracer(exp Exporter) *Tracer {
+	return &Tracer{exporter: exp}
+}
+
+// Shutdown flushes the exporter.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	return t.exporter.Shutdown(ctx)
+}
+
+// Start begins a span. If ctx already carries a span the new span becomes
+// its child (and is exported by the parent's tracer); otherwise a new trace
+// is started. A nil Tracer behaves like the package-level Start.
+func (t *Tracer) Start(ctx context.Context, name string, attrs ...Attr) (context.Context, *Span) {
+	parent := FromContext(ctx)
+	if parent != nil {
+		return startChild(ctx, parent, name, attrs)
+	}
+	if t == nil {
+		return ctx, nil
+	}
+	s := &Span{tracer: t, data: SpanData{Name: name, Start: time.Now(), Attrs: slices.Clone(attrs)}}
+	rand.Read(s.data.TraceID[:])
+	rand.Read(s.data.SpanID[:])
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// Start begins a child of the span in ctx. Without a span in ctx tracing is
+// disabled for this call path and the returned span is nil.
+func Start(ctx context.Context, name string, attrs ...Attr) (context.Context, *Span) {
+	parent := FromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	return startChild(ctx, parent, name, attrs)
+}
+
+func startChild(ctx context.Context, parent *Span, name string, attrs []Attr) (context.Context, *Span) {
+	s := &Span{tracer: parent.tracer, data: SpanData{
+		TraceID:  parent.data.TraceID,
+		ParentID: parent.data.SpanID,
+		Name:     name,
+		Start:    time.Now(),
+		Attrs:    slices.Clone(attrs),
+	}}
+	rand.Read(s.data.SpanID[:])
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+type spanKey struct{}
+
+// FromContext returns the current span, or nil.
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// Span is an in-progress operation. Its methods are safe for concurrent use
+// and do nothing on a nil Span or after End.
+type Span struct {
+	tracer *Tracer
+	mu     sync.Mutex
+	data   SpanData
+	ended  bool
+}
+
+// TraceID returns the span's trace id, or the zero id for a nil span.
+func (s *Span) TraceID() TraceID {
+	if s == nil {
+		return TraceID{}
+	}
+	return s.data.TraceID
+}
+
+// SetAttrs adds or overwrites attributes.
+func (s *Span) SetAttrs(attrs ...Attr) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return
+	}
+outer:
+	for _, a := range attrs {
+		for i := range s.data.Attrs {
+			if s.data.Attrs[i].Key == a.Key {
+				s.data.Attrs[i] = a
+				continue outer
+			}
+		}
+		s.data.Attrs = append(s.data.Attrs, a)
+	}
+}
+
+// RecordError marks the span as failed. A nil err is ignored.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.SetStatus(StatusError, err.Error())
+}
+
+// SetStatus sets the span status.
+func (s *Span) SetStatus(code Status, msg string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.ended {
+		s.data.Status, s.data.StatusMsg = code, msg
+	}
+}
+
+// End finishes the span and exports it. Only the first call has an effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mu.Unlock()
+	s.tracer.exporter.Export(data)
+}
+
+// formatValue renders a non-primitive attribute value.
+func formatValue(v any) string {
+	if s, ok := v.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return fmt.Sprint(v)
+}
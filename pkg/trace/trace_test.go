@@ -0,0 +1,76 @@
+package trace_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/eastlaugh/agent/pkg/trace"
+)
+
+func TestSpanTree(t *testing.T) {
+	var buf bytes.Buffer
+	tr := trace.NewTracer(trace.NewWriterExporter(&buf))
+
+	ctx, run := tr.Start(context.Background(), "run")
+	_, step := trace.Start(ctx, "step", trace.Int("n", 1))
+	step.RecordError(errors.New("boom"))
+	step.End()
+	run.End()
+	run.End() // 重复 End 无效
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("want 2 spans, got %q", buf.String())
+	}
+	if !strings.Contains(lines[0], "parent=") || !strings.Contains(lines[0], `n="1"`) || !strings.Contains(lines[0], `error="boom"`) {
+		t.Fatalf("unexpected child span line %q", lines[0])
+	}
+	if !strings.Contains(lines[0], run.TraceID().String()) {
+		t.Fatalf("child span not in parent's trace: %q", lines[0])
+	}
+
+	// 没有父 span 时不记录
+	if _, s := trace.Start(context.Background(), "orphan"); s != nil {
+		t.Fatal("want nil span without parent")
+	}
+}
+
+func TestOTLPFile(t *testing.T) {
+	var buf bytes.Buffer
+	exp := trace.NewOTLPFileExporter(&buf, "agent-test")
+	tr := trace.NewTracer(exp)
+	_, s := tr.Start(context.Background(), "run", trace.String("k", "v"), trace.Int("i", 3))
+	s.End()
+	if err := tr.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var doc struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []struct {
+					TraceID    string `json:"traceId"`
+					Name       string `json:"name"`
+					Attributes []struct {
+						Key   string         `json:"key"`
+						Value map[string]any `json:"value"`
+					} `json:"attributes"`
+				} `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	span := doc.ResourceSpans[0].ScopeSpans[0].Spans[0]
+	if span.Name != "run" || len(span.TraceID) != 32 {
+		t.Fatalf("unexpected span %+v", span)
+	}
+	if span.Attributes[1].Value["intValue"] != "3" {
+		t.Fatalf("int attribute not encoded as OTLP intValue: %v", span.Attributes[1].Value)
+	}
+}
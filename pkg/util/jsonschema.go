@@ -0,0 +1,221 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// JSONSchema 根据 Go 类型生成 JSON Schema：结构体按 json 标签生成属性，
+// 未标 omitempty 的字段为必填；interface 类型不做限制
+func JSONSchema(t reflect.Type) map[string]any {
+	return jsonSchema(t, map[reflect.Type]bool{})
+}
+
+func jsonSchema(t reflect.Type, seen map[reflect.Type]bool) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "contentEncoding": "base64"}
+		}
+		return map[string]any{"type": "array", "items": jsonSchema(t.Elem(), seen)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": jsonSchema(t.Elem(), seen)}
+	case reflect.Struct:
+		if seen[t] {
+			return map[string]any{"type": "object"} // 递归类型不再展开
+		}
+		seen[t] = true
+		defer delete(seen, t)
+
+		props := map[string]any{}
+		var required []string
+		for _, f := range reflect.VisibleFields(t) {
+			if !f.IsExported() || f.Anonymous {
+				continue
+			}
+			name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			s := jsonSchema(f.Type, seen)
+			if desc := f.Tag.Get("desc"); desc != "" {
+				s["description"] = desc
+			}
+			props[name] = s
+			if !strings.Contains(opts, "omitempty") && f.Type.Kind() != reflect.Pointer {
+				required = append(required, name)
+			}
+		}
+		s := map[string]any{"type": "object", "properties": props, "additionalProperties": false}
+		if len(required) > 0 {
+			s["required"] = required
+		}
+		return s
+	}
+	return map[string]any{}
+}
+
+// FuncSchema 为函数参数生成对象 Schema，Go 没有保留参数名，因此属性依次命名为 arg0、arg1……
+func FuncSchema(fn any) map[string]any {
+	t := reflect.TypeOf(fn)
+	props := map[string]any{}
+	required := []string{}
+	for i := 0; i < t.NumIn(); i++ {
+		name := fmt.Sprintf("arg%d", i)
+		s := jsonSchema(t.In(i), map[reflect.Type]bool{})
+		s["description"] = "Go type " + t.In(i).String()
+		props[name] = s
+		required = append(required, name)
+	}
+	return map[string]any{"type": "object", "properties": props, "required": required}
+}
+
+// CallFuncJSON 与 CallFunc 相同，但参数来自 FuncSchema 形式的 JSON 对象 {"arg0": ..., "arg1": ...}，
+// 因此字符串参数可以包含空格。参数无法解码时返回错误，函数本身的恐慌不做处理
+func CallFuncJSON(fn any, args map[string]json.RawMessage) (output string, argsAny []any, err error) {
+	rv := reflect.ValueOf(fn)
+	typ := rv.Type()
+
+	in := make([]reflect.Value, typ.NumIn())
+	for i := range in {
+		name := fmt.Sprintf("arg%d", i)
+		raw, ok := args[name]
+		if !ok {
+			return "", nil, fmt.Errorf("missing argument %s", name)
+		}
+		v := reflect.New(typ.In(i))
+		if err := json.Unmarshal(raw, v.Interface()); err != nil {
+			return "", nil, fmt.Errorf("argument %s: %w", name, err)
+		}
+		in[i] = v.Elem()
+		argsAny = append(argsAny, in[i].Interface())
+	}
+	if len(args) > len(in) {
+		return "", nil, fmt.Errorf("expected %d arguments, got %d", len(in), len(args))
+	}
+
+	var results []reflect.Value
+	if typ.IsVariadic() {
+		results = rv.CallSlice(in)
+	} else {
+		results = rv.Call(in)
+	}
+	if len(results) == 0 {
+		panic("divergent function")
+	}
+	return MarshalReturn(results), argsAny, nil
+}
+
+// ValidateJSON 按 JSONSchema 生成的 Schema 校验 json.Unmarshal 得到的值 v：类型、必填属性、
+// 多余属性与数组元素，返回第一处不符合的位置，如 $.items[1].name
+func ValidateJSON(schema map[string]any, v any) error {
+	return validateJSON(schema, v, "$")
+}
+
+func validateJSON(s map[string]any, v any, path string) error {
+	typ, _ := s["type"].(string)
+	if typ != "" && !jsonHasType(v, typ) {
+		return fmt.Errorf("%s: expected %s, got %s", path, typ, jsonTypeName(v))
+	}
+	switch typ {
+	case "object":
+		obj := v.(map[string]any)
+		required, _ := s["required"].([]string)
+		for _, name := range required {
+			if _, ok := obj[name]; !ok {
+				return fmt.Errorf("%s: missing required property %q", path, name)
+			}
+		}
+		props, _ := s["properties"].(map[string]any)
+		for _, name := range sortedKeys(obj) {
+			val := obj[name]
+			p, ok := props[name].(map[string]any)
+			if !ok {
+				switch extra := s["additionalProperties"].(type) {
+				case bool:
+					if !extra {
+						return fmt.Errorf("%s: unknown property %q", path, name)
+					}
+				case map[string]any:
+					if err := validateJSON(extra, val, path+"."+name); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			// 可选属性允许为 null，对应 Go 的指针与零值
+			if val == nil && !slices.Contains(required, name) {
+				continue
+			}
+			if err := validateJSON(p, val, path+"."+name); err != nil {
+				return err
+			}
+		}
+	case "array":
+		items, _ := s["items"].(map[string]any)
+		for i, item := range v.([]any) {
+			if err := validateJSON(items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func jsonHasType(v any, typ string) bool {
+	switch typ {
+	case "integer":
+		f, ok := v.(float64)
+		return ok && f == math.Trunc(f)
+	case "number":
+		_, ok := v.(float64)
+		return ok
+	}
+	return jsonTypeName(v) == typ
+}
+
+func jsonTypeName(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
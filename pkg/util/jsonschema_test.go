@@ -0,0 +1,47 @@
+package util_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/eastlaugh/agent/pkg/util"
+)
+
+type item struct {
+	ID    int     `json:"id"`
+	Note  string  `json:"note,omitempty" desc:"free text"`
+	Price float64 `json:"price"`
+}
+
+type order struct {
+	Items []item          `json:"items"`
+	Meta  map[string]bool `json:"meta,omitempty"`
+	Next  *order          `json:"next,omitempty"`
+}
+
+func TestValidateJSON(t *testing.T) {
+	schema := util.JSONSchema(reflect.TypeFor[order]())
+	if got := schema["properties"].(map[string]any)["items"].(map[string]any)["items"].(map[string]any)["properties"].(map[string]any)["note"].(map[string]any)["description"]; got != "free text" {
+		t.Errorf("note description = %v", got)
+	}
+	for doc, want := range map[string]string{
+		`{"items": [{"id": 1, "price": 2.5}], "meta": {"a": true}}`: "",
+		`{"items": [], "next": {"items": []}}`:                      "",
+		`{"items": [], "next": null}`:                               "",
+		`{}`:                                                        `$: missing required property "items"`,
+		`{"items": [{"id": 1.5, "price": 1}]}`:                      "$.items[0].id: expected integer, got number",
+		`{"items": [{"id": 1, "price": 1, "qty": 2}]}`:              `$.items[0]: unknown property "qty"`,
+		`{"items": [], "meta": {"a": "yes"}}`:                       "$.meta.a: expected boolean, got string",
+	} {
+		var v any
+		if err := json.Unmarshal([]byte(doc), &v); err != nil {
+			t.Fatal(err)
+		}
+		err := util.ValidateJSON(schema, v)
+		if want == "" && err != nil || want != "" && (err == nil || !strings.Contains(err.Error(), want)) {
+			t.Errorf("%s: err = %v, want %q", doc, err, want)
+		}
+	}
+}
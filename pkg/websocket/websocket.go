@@ -0,0 +1,311 @@
+// Package websocket is a minimal server-side RFC 6455 implementation built on
+// net/http. It supports text/binary messages, fragmentation, ping/pong and the
+// closing handshake, which is all the agent server needs.
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Opcodes defined by RFC 6455.
+const (
+	OpContinuation = 0x0
+	OpText         = 0x1
+	OpBinary       = 0x2
+	OpClose        = 0x8
+	OpPing         = 0x9
+	OpPong         = 0xA
+)
+
+// Close status codes used by this package.
+const (
+	CloseNormal         = 1000
+	CloseGoingAway      = 1001
+	CloseProtocolError  = 1002
+	CloseMessageTooBig  = 1009
+	CloseInternalError  = 1011
+	closeNoStatusRecvd  = 1005
+	maxControlFrameSize = 125
+)
+
+// DefaultMaxMessageSize limits the size of a single (reassembled) message.
+const DefaultMaxMessageSize = 1 << 20
+
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// ErrClosed is returned by ReadMessage after the closing handshake completed.
+var ErrClosed = errors.New("websocket: connection closed")
+
+// CloseError is returned by ReadMessage when the peer sent a close frame.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket: close %d %s", e.Code, e.Reason)
+}
+
+// Conn is a server-side WebSocket connection. ReadMessage must be called from
+// a single goroutine; the write methods are safe for concurrent use.
+type Conn struct {
+	conn net.Conn
+	br   *bufio.Reader
+
+	wmu    sync.Mutex
+	closed bool
+
+	// MaxMessageSize limits incoming messages, DefaultMaxMessageSize if zero.
+	MaxMessageSize int64
+	// PongHandler is called for every pong frame received, if set.
+	PongHandler func(data []byte)
+}
+
+// Upgrade performs the opening handshake and hijacks the connection.
+// On failure an HTTP error has already been written to w.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if r.Method != http.MethodGet ||
+		!headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "websocket upgrade required", http.StatusUpgradeRequired)
+		return nil, errors.New("websocket: not a websocket handshake")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusBadRequest)
+		return nil, errors.New("websocket: unsupported version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "missing Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, errors.New("websocket: missing key")
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return nil, errors.New("websocket: response does not implement http.Hijacker")
+	}
+	netConn, brw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + AcceptKey(key) + "\r\n\r\n"
+	if _, err := netConn.Write([]byte(resp)); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	// Clear the handshake deadline; callers bound idle connections with heartbeats.
+	netConn.SetDeadline(time.Time{})
+	return &Conn{conn: netConn, br: brw.Reader}, nil
+}
+
+// AcceptKey computes the Sec-WebSocket-Accept value for a client key.
+func AcceptKey(key string) string {
+	h := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, part := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ReadMessage returns the next text or binary message. Control frames are
+// handled transparently: pings are answered, pongs go to PongHandler and a
+// close frame is echoed before a *CloseError is returned.
+func (c *Conn) ReadMessage() (opcode int, data []byte, err error) {
+	limit := c.MaxMessageSize
+	if limit <= 0 {
+		limit = DefaultMaxMessageSize
+	}
+	var msg []byte
+	opcode = -1
+	for {
+		fin, op, payload, err := c.readFrame(limit)
+		if err != nil {
+			return 0, nil, err
+		}
+		switch op {
+		case OpPing:
+			if err := c.WriteControl(OpPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case OpPong:
+			if c.PongHandler != nil {
+				c.PongHandler(payload)
+			}
+			continue
+		case OpClose:
+			code, reason := closeNoStatusRecvd, ""
+			if len(payload) >= 2 {
+				code = int(binary.BigEndian.Uint16(payload))
+				reason = string(payload[2:])
+			}
+			c.Close(CloseNormal, "")
+			return 0, nil, &CloseError{Code: code, Reason: reason}
+		case OpText, OpBinary:
+			if opcode != -1 {
+				c.Close(CloseProtocolError, "expected continuation frame")
+				return 0, nil, errors.New("websocket: unexpected data frame")
+			}
+			opcode = op
+		case OpContinuation:
+			if opcode == -1 {
+				c.Close(CloseProtocolError, "unexpected continuation frame")
+				return 0, nil, errors.New("websocket: unexpected continuation frame")
+			}
+		default:
+			c.Close(CloseProtocolError, "unknown opcode")
+			return 0, nil, fmt.Errorf("websocket: unknown opcode %d", op)
+		}
+
+		if int64(len(msg)+len(payload)) > limit {
+			c.Close(CloseMessageTooBig, "")
+			return 0, nil, errors.New("websocket: message too big")
+		}
+		msg = append(msg, payload...)
+		if fin {
+			return opcode, msg, nil
+		}
+	}
+}
+
+func (c *Conn) readFrame(limit int64) (fin bool, opcode int, payload []byte, err error) {
+	var head [2]byte
+	if _, err = io.ReadFull(c.br, head[:]); err != nil {
+		return
+	}
+	fin = head[0]&0x80 != 0
+	if head[0]&0x70 != 0 {
+		c.Close(CloseProtocolError, "reserved bits set")
+		return false, 0, nil, errors.New("websocket: reserved bits set")
+	}
+	opcode = int(head[0] & 0x0F)
+	masked := head[1]&0x80 != 0
+	length := int64(head[1] & 0x7F)
+
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		length = int64(binary.BigEndian.Uint64(ext[:]))
+	}
+
+	if !masked {
+		// Frames from a client must be masked.
+		c.Close(CloseProtocolError, "unmasked client frame")
+		return false, 0, nil, errors.New("websocket: unmasked client frame")
+	}
+	if opcode >= OpClose && (length > maxControlFrameSize || !fin) {
+		c.Close(CloseProtocolError, "invalid control frame")
+		return false, 0, nil, errors.New("websocket: invalid control frame")
+	}
+	if length < 0 || length > limit {
+		c.Close(CloseMessageTooBig, "")
+		return false, 0, nil, errors.New("websocket: frame too big")
+	}
+
+	var mask [4]byte
+	if _, err = io.ReadFull(c.br, mask[:]); err != nil {
+		return
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(c.br, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return
+}
+
+// WriteMessage sends a single unfragmented text or binary message.
+func (c *Conn) WriteMessage(opcode int, data []byte) error {
+	return c.writeFrame(opcode, data)
+}
+
+// WriteControl sends a ping, pong or close frame.
+func (c *Conn) WriteControl(opcode int, data []byte) error {
+	if len(data) > maxControlFrameSize {
+		return errors.New("websocket: control frame too big")
+	}
+	return c.writeFrame(opcode, data)
+}
+
+func (c *Conn) writeFrame(opcode int, data []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closed {
+		return ErrClosed
+	}
+
+	// Frames from the server are never masked.
+	frame := make([]byte, 0, len(data)+10)
+	frame = append(frame, 0x80|byte(opcode))
+	switch n := len(data); {
+	case n < 126:
+		frame = append(frame, byte(n))
+	case n <= 0xFFFF:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	frame = append(frame, data...)
+
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, err := c.conn.Write(frame)
+	if opcode == OpClose {
+		c.closed = true
+	}
+	return err
+}
+
+// SetReadDeadline sets the deadline for the next ReadMessage call, useful for
+// dropping peers that stopped answering heartbeats.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// Close sends a close frame with the given code and reason (if not already
+// sent) and closes the underlying connection.
+func (c *Conn) Close(code int, reason string) error {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	payload = append(payload, reason...)
+	if len(payload) > maxControlFrameSize {
+		payload = payload[:maxControlFrameSize]
+	}
+	c.writeFrame(OpClose, payload)
+	return c.conn.Close()
+}
@@ -0,0 +1,111 @@
+package websocket_test
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/eastlaugh/agent/pkg/websocket"
+)
+
+func TestAcceptKey(t *testing.T) {
+	// The example from RFC 6455 section 1.3.
+	if got := websocket.AcceptKey("dGhlIHNhbXBsZSBub25jZQ=="); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("AcceptKey = %s", got)
+	}
+}
+
+// writeClientFrame writes a masked frame, as a client would.
+func writeClientFrame(w io.Writer, fin bool, opcode byte, payload []byte) error {
+	b0 := opcode
+	if fin {
+		b0 |= 0x80
+	}
+	frame := []byte{b0, 0x80 | byte(len(payload))}
+	mask := []byte{1, 2, 3, 4}
+	frame = append(frame, mask...)
+	for i, c := range payload {
+		frame = append(frame, c^mask[i%4])
+	}
+	_, err := w.Write(frame)
+	return err
+}
+
+func readServerFrame(r *bufio.Reader) (opcode byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err = io.ReadFull(r, head[:]); err != nil {
+		return
+	}
+	n := int(head[1] & 0x7F)
+	if n == 126 {
+		var ext [2]byte
+		io.ReadFull(r, ext[:])
+		n = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	payload = make([]byte, n)
+	_, err = io.ReadFull(r, payload)
+	return head[0] & 0x0F, payload, err
+}
+
+func TestEcho(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		for {
+			op, data, err := conn.ReadMessage()
+			if err != nil {
+				var ce *websocket.CloseError
+				if !errors.As(err, &ce) {
+					t.Error(err)
+				}
+				return
+			}
+			conn.WriteMessage(op, data)
+		}
+	}))
+	defer srv.Close()
+
+	c, err := net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	io.WriteString(c, "GET / HTTP/1.1\r\nHost: x\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n"+
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n")
+	br := bufio.NewReader(c)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("bad handshake: %v %v", resp.Status, resp.Header)
+	}
+
+	// A ping is answered with a pong right away and fragments are reassembled.
+	writeClientFrame(c, true, websocket.OpPing, []byte("hb"))
+	writeClientFrame(c, false, websocket.OpText, []byte("你好，"))
+	writeClientFrame(c, true, websocket.OpContinuation, []byte("世界"))
+
+	op, payload, err := readServerFrame(br)
+	if err != nil || op != websocket.OpPong || string(payload) != "hb" {
+		t.Fatalf("want pong, got %d %q %v", op, payload, err)
+	}
+	op, payload, err = readServerFrame(br)
+	if err != nil || op != websocket.OpText || string(payload) != "你好，世界" {
+		t.Fatalf("want echo, got %d %q %v", op, payload, err)
+	}
+
+	writeClientFrame(c, true, websocket.OpClose, binary.BigEndian.AppendUint16(nil, websocket.CloseNormal))
+	op, _, err = readServerFrame(br)
+	if err != nil || op != websocket.OpClose {
+		t.Fatalf("want close, got %d %v", op, err)
+	}
+}